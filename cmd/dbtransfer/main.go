@@ -0,0 +1,139 @@
+// Command dbtransfer exports and imports datastore entities as JSON Lines
+// through the App Engine remote API of a running app
+//
+// Usage:
+//
+//	dbtransfer -host my-app.appspot.com -kind Foo export > foo.jsonl
+//	dbtransfer -host my-app.appspot.com export > everything.jsonl
+//	dbtransfer -host my-other-app.appspot.com -namespace staging -dry-run import < foo.jsonl
+//
+// Credentials are found with the Application Default Credentials
+// and the app must have the remote API enabled.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/oauth2/google"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/remote_api"
+
+	"github.com/benjamw/golibs/db"
+)
+
+var (
+	host      = flag.String("host", "localhost:8080", "host of the app to connect to")
+	namespace = flag.String("namespace", "", "namespace to export from or import into")
+	kind      = flag.String("kind", "", "kind to export (the whole namespace if empty)")
+	file      = flag.String("file", "", "file to write to or read from (stdout or stdin if empty)")
+	dryRun    = flag.Bool("dry-run", false, "decode the import without saving anything")
+	batchSize = flag.Int("batch", db.DefaultImportBatchSize, "number of entities saved per batch on import")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] export|import\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx, err := remoteContext(context.Background())
+	if err != nil {
+		fatal(err)
+	}
+
+	var n int
+	switch flag.Arg(0) {
+	case "export":
+		n, err = export(ctx)
+	case "import":
+		n, err = load(ctx)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fatal(err)
+	}
+
+	fmt.Fprintf(os.Stderr, "%s: %d entities\n", flag.Arg(0), n)
+}
+
+func remoteContext(ctx context.Context) (context.Context, error) {
+	hc, err := google.DefaultClient(ctx,
+		"https://www.googleapis.com/auth/appengine.apis",
+		"https://www.googleapis.com/auth/userinfo.email",
+		"https://www.googleapis.com/auth/cloud-platform",
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return remote_api.NewRemoteContext(*host, hc)
+}
+
+func export(ctx context.Context) (n int, err error) {
+	if *namespace != "" {
+		if ctx, err = appengine.Namespace(ctx, *namespace); err != nil {
+			return
+		}
+	}
+
+	var w io.Writer = os.Stdout
+	if *file != "" {
+		var f *os.File
+		if f, err = os.Create(*file); err != nil {
+			return
+		}
+		// a failed flush only shows up when closing, so its error isn't dropped
+		defer func() {
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+		}()
+		w = f
+	}
+
+	if *kind == "" {
+		return db.ExportNamespace(ctx, w)
+	}
+
+	return db.Export(ctx, w, *kind)
+}
+
+func load(ctx context.Context) (n int, err error) {
+	var r io.Reader = os.Stdin
+	if *file != "" {
+		f, err := os.Open(*file)
+		if err != nil {
+			return 0, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	opts := &db.ImportOptions{
+		DryRun:    *dryRun,
+		BatchSize: *batchSize,
+	}
+	if *namespace != "" {
+		opts.Remap = db.RemapNamespace(*namespace)
+	}
+
+	return db.Import(ctx, r, opts)
+}
+
+func fatal(err error) {
+	fmt.Fprintf(os.Stderr, "dbtransfer: %v\n", err)
+	os.Exit(1)
+}
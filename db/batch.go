@@ -0,0 +1,211 @@
+package db
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"google.golang.org/appengine/datastore"
+)
+
+const (
+	// BatchCheckpointKind is the kind of the entities that store the progress of a Batch
+	BatchCheckpointKind = "BatchCheckpoint"
+
+	// DefaultBatchSize is the number of entities a Batch processes per chunk
+	DefaultBatchSize = 100
+)
+
+// Batch walks every entity of a kind in key order and applies Fn to each of them,
+// saving the changed ones in chunks
+//
+// The progress is checkpointed after every chunk, so a Batch that gets interrupted
+// picks up where it left off the next time it is run with the same Name.
+type Batch struct {
+	Name string // unique name of the batch, used for its checkpoint (reusing it for another Kind is an error)
+	Kind string // kind to walk, it must be registered with RegisterKind
+	Size int    // number of entities per chunk (larger sizes are clamped to 500), defaults to DefaultBatchSize
+
+	// Fn is called for every entity and reports whether it changed the entity
+	// An error counts the entity as failed, and the batch moves on
+	Fn func(ctx context.Context, m Model) (changed bool, err error)
+
+	// DryRun runs Fn without saving any changes or checkpoints
+	DryRun bool
+}
+
+// BatchResult holds the counts of a Batch, over every run since it was started
+type BatchResult struct {
+	Processed int  // entities passed to Fn
+	Changed   int  // entities Fn changed
+	Failed    int  // entities Fn threw an error for
+	Missing   int  // entities deleted after they were listed, before they could be loaded
+	Done      bool // whether every entity has been processed
+}
+
+// batchCheckpoint is the stored progress of a Batch, keyed by its name
+type batchCheckpoint struct {
+	Kind      string
+	Cursor    string `datastore:",noindex"`
+	Processed int    `datastore:",noindex"`
+	Changed   int    `datastore:",noindex"`
+	Failed    int    `datastore:",noindex"`
+	Missing   int    `datastore:",noindex"`
+	Done      bool
+	Updated   time.Time
+}
+
+// Run processes the entities, starting after the last checkpoint
+// A batch that is already done does nothing, use Reset to run it again
+func (b *Batch) Run(ctx context.Context) (res BatchResult, myerr error) {
+	size := b.Size
+	if size <= 0 {
+		size = DefaultBatchSize
+	}
+	if size > maxBatchKeys {
+		size = maxBatchKeys
+	}
+
+	// fail early if the kind can't be loaded
+	if _, myerr = NewModel(b.Kind); myerr != nil {
+		return
+	}
+
+	var cp batchCheckpoint
+	ck := b.checkpointKey(ctx)
+	myerr = retry(ctx, true, func() error {
+		return datastore.Get(ctx, ck, &cp)
+	})
+	if myerr != nil && myerr != datastore.ErrNoSuchEntity {
+		return
+	}
+	myerr = nil
+
+	// the cursor of a checkpoint only works for the query of its kind
+	if cp.Kind != "" && cp.Kind != b.Kind {
+		myerr = &BatchKindError{Name: b.Name, Kind: b.Kind, CheckpointKind: cp.Kind}
+		return
+	}
+
+	cp.Kind = b.Kind
+	for !cp.Done {
+		if myerr = ctx.Err(); myerr != nil {
+			break
+		}
+
+		q := datastore.NewQuery(b.Kind).Order("__key__").KeysOnly().Limit(size)
+		if cp.Cursor != "" {
+			var c datastore.Cursor
+			if c, myerr = datastore.DecodeCursor(cp.Cursor); myerr != nil {
+				break
+			}
+			q = q.Start(c)
+		}
+
+		var keys []*datastore.Key
+		var cursor datastore.Cursor
+		myerr = retry(ctx, true, func() (err error) {
+			keys = keys[:0]
+			t := q.Run(ctx)
+			for {
+				var k *datastore.Key
+				if k, err = t.Next(nil); err == datastore.Done {
+					break
+				}
+				if err != nil {
+					return
+				}
+				keys = append(keys, k)
+			}
+
+			cursor, err = t.Cursor()
+			return
+		})
+		if myerr != nil {
+			break
+		}
+
+		if myerr = b.chunk(ctx, keys, &cp); myerr != nil {
+			break
+		}
+
+		cp.Cursor = cursor.String()
+		cp.Done = len(keys) < size
+		cp.Updated = time.Now()
+
+		if !b.DryRun {
+			// the chunk is done, so record it even if the batch is being interrupted
+			wc := context.WithoutCancel(ctx)
+			myerr = retry(wc, true, func() (err error) {
+				_, err = datastore.Put(wc, ck, &cp)
+				return
+			})
+			if myerr != nil {
+				break
+			}
+		}
+	}
+
+	res = BatchResult{
+		Processed: cp.Processed,
+		Changed:   cp.Changed,
+		Failed:    cp.Failed,
+		Missing:   cp.Missing,
+		Done:      cp.Done,
+	}
+
+	return
+}
+
+// Reset deletes the checkpoint of the batch so the next Run starts from the beginning
+func (b *Batch) Reset(ctx context.Context) error {
+	return retry(ctx, true, func() error {
+		return datastore.Delete(ctx, b.checkpointKey(ctx))
+	})
+}
+
+// chunk loads the entities with the given keys, applies Fn and saves the changed ones
+// Entities deleted since the keys were listed are skipped.
+func (b *Batch) chunk(ctx context.Context, keys []*datastore.Key, cp *batchCheckpoint) (myerr error) {
+	if len(keys) == 0 {
+		return
+	}
+
+	models, myerr := loadExisting(ctx, b.Kind, keys)
+	if myerr != nil {
+		return
+	}
+
+	var changed []Model
+	failed := 0
+	for _, m := range models {
+		c, err := b.Fn(ctx, m)
+		if err != nil {
+			failed++
+			getLogger().LogAttrs(ctx, slog.LevelInfo, "batch failed on an entity",
+				slog.String("batch", b.Name), slog.String("key", m.GetKey().String()), slog.Any("error", err))
+			continue
+		}
+
+		if c {
+			changed = append(changed, m)
+		}
+	}
+
+	if len(changed) > 0 && !b.DryRun {
+		if myerr = SaveMulti(ctx, changed); myerr != nil {
+			return
+		}
+	}
+
+	cp.Processed += len(models)
+	cp.Changed += len(changed)
+	cp.Failed += failed
+	cp.Missing += len(keys) - len(models)
+
+	return
+}
+
+func (b *Batch) checkpointKey(ctx context.Context) *datastore.Key {
+	return datastore.NewKey(ctx, BatchCheckpointKind, b.Name, 0, nil)
+}
@@ -0,0 +1,208 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"google.golang.org/appengine/datastore"
+)
+
+func TestBatch(t *testing.T) {
+	defer ResetDB()
+	ctx := GetCtx()
+
+	RegisterKind(new(Foo).EntityType(), func() Model { return new(Foo) })
+
+	for i := int64(1); i <= 5; i++ {
+		createFullFoo(ctx, t, "before", i)
+	}
+
+	b := &Batch{
+		Name: "TestBatch",
+		Kind: new(Foo).EntityType(),
+		Size: 2,
+		Fn: func(ctx context.Context, m Model) (bool, error) {
+			f := m.(*Foo)
+			switch {
+			case f.Int == 5:
+				return false, errors.New("five")
+			case f.Int%2 == 0:
+				return false, nil
+			}
+
+			f.String = "after"
+			return true, nil
+		},
+	}
+
+	// a dry run counts but doesn't change anything
+	b.DryRun = true
+	res, err := b.Run(ctx)
+	if err != nil {
+		t.Fatalf("Run threw an error on a dry run. Error: %v", err)
+	}
+	if res.Processed != 5 || res.Changed != 2 || res.Failed != 1 || !res.Done {
+		t.Fatalf("Run returned the wrong dry run result. Got: %+v", res)
+	}
+
+	var foos []*Foo
+	if _, err = Query(ctx, NewRepo[*Foo]().Query().Datastore(), &foos); err != nil {
+		t.Fatalf("Query threw an error. Error: %v", err)
+	}
+	for _, f := range foos {
+		if f.String != "before" {
+			t.Fatal("Run changed an entity on a dry run")
+		}
+	}
+
+	b.DryRun = false
+	res, err = b.Run(ctx)
+	if err != nil {
+		t.Fatalf("Run threw an error. Error: %v", err)
+	}
+	if res.Processed != 5 || res.Changed != 2 || res.Failed != 1 || !res.Done {
+		t.Fatalf("Run returned the wrong result. Got: %+v", res)
+	}
+
+	for _, f := range foos {
+		var m Foo
+		if _, err = Load(ctx, f.GetKey(), &m); err != nil {
+			t.Fatalf("Load threw an error. Error: %v", err)
+		}
+
+		want := "before"
+		if m.Int == 1 || m.Int == 3 {
+			want = "after"
+		}
+		if m.String != want {
+			t.Fatalf("Run did not save the changes for Foo %d. Wanted: %s; Got: %s", m.Int, want, m.String)
+		}
+	}
+
+	// a finished batch doesn't run again until it is reset
+	res, err = b.Run(ctx)
+	if err != nil || res.Processed != 5 {
+		t.Fatalf("Run processed a finished batch again. Error: %v; Got: %+v", err, res)
+	}
+
+	if err = b.Reset(ctx); err != nil {
+		t.Fatalf("Reset threw an error. Error: %v", err)
+	}
+
+	res, err = b.Run(ctx)
+	if err != nil || res.Processed != 5 || res.Changed != 0 {
+		t.Fatalf("Run did not start over after Reset. Error: %v; Got: %+v", err, res)
+	}
+}
+
+func TestBatchResume(t *testing.T) {
+	defer ResetDB()
+	ctx := GetCtx()
+
+	RegisterKind(new(Foo).EntityType(), func() Model { return new(Foo) })
+
+	for i := int64(1); i <= 5; i++ {
+		createFullFoo(ctx, t, "resume", i)
+	}
+
+	seen := make(map[int64]int)
+	c, cancel := context.WithCancel(ctx)
+	b := &Batch{
+		Name: "TestBatchResume",
+		Kind: new(Foo).EntityType(),
+		Size: 2,
+		Fn: func(ctx context.Context, m Model) (bool, error) {
+			seen[m.(*Foo).Int]++
+
+			// interrupt after the first chunk
+			if len(seen) == 2 {
+				cancel()
+			}
+			return false, nil
+		},
+	}
+
+	res, err := b.Run(c)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Run did not stop when interrupted. Got: %v", err)
+	}
+	if res.Processed != 2 || res.Done {
+		t.Fatalf("Run returned the wrong result when interrupted. Got: %+v", res)
+	}
+
+	res, err = b.Run(ctx)
+	if err != nil {
+		t.Fatalf("Run threw an error when resuming. Error: %v", err)
+	}
+	if res.Processed != 5 || !res.Done {
+		t.Fatalf("Run returned the wrong result when resuming. Got: %+v", res)
+	}
+
+	for i, n := range seen {
+		if n != 1 {
+			t.Fatalf("Run processed Foo %d %d times", i, n)
+		}
+	}
+}
+
+func TestBatchKindChanged(t *testing.T) {
+	defer ResetDB()
+	ctx := GetCtx()
+
+	RegisterKind(new(Foo).EntityType(), func() Model { return new(Foo) })
+	RegisterKind(new(Note).EntityType(), func() Model { return new(Note) })
+
+	createFoo(ctx, t)
+
+	fn := func(ctx context.Context, m Model) (bool, error) { return false, nil }
+	if _, err := (&Batch{Name: "TestBatchKindChanged", Kind: new(Foo).EntityType(), Fn: fn}).Run(ctx); err != nil {
+		t.Fatalf("Run threw an error. Error: %v", err)
+	}
+
+	// the checkpoint of the Foo batch can't be used for Notes
+	_, err := (&Batch{Name: "TestBatchKindChanged", Kind: new(Note).EntityType(), Fn: fn}).Run(ctx)
+	if _, ok := err.(*BatchKindError); !ok {
+		t.Fatalf("Run did not throw a BatchKindError. Got: %T: %v", err, err)
+	}
+}
+
+func TestBatchMissing(t *testing.T) {
+	defer ResetDB()
+	ctx := GetCtx()
+
+	RegisterKind(new(Foo).EntityType(), func() Model { return new(Foo) })
+
+	a, c := createFoo(ctx, t), createFoo(ctx, t)
+	keys := []*datastore.Key{a.GetKey(), c.GetKey()}
+
+	// an entity deleted after its key was listed is skipped
+	if err := datastore.Delete(ctx, c.GetKey()); err != nil {
+		t.Fatalf("Could not delete a Foo. Error: %v", err)
+	}
+
+	var seen int
+	b := &Batch{
+		Name: "TestBatchMissing",
+		Kind: new(Foo).EntityType(),
+		Fn: func(ctx context.Context, m Model) (bool, error) {
+			seen++
+			return false, nil
+		},
+	}
+
+	var cp batchCheckpoint
+	if err := b.chunk(ctx, keys, &cp); err != nil {
+		t.Fatalf("chunk threw an error for a deleted entity. Error: %v", err)
+	}
+	if seen != 1 || cp.Processed != 1 || cp.Missing != 1 {
+		t.Fatalf("chunk did not skip the deleted entity. Got: %d seen, %+v", seen, cp)
+	}
+}
+
+func TestBatchUnregistered(t *testing.T) {
+	_, err := (&Batch{Name: "Unregistered", Kind: "Unregistered"}).Run(GetCtx())
+	if _, ok := err.(*UnregisteredKindError); !ok {
+		t.Fatalf("Run did not throw an UnregisteredKindError. Got: %T: %v", err, err)
+	}
+}
@@ -0,0 +1,452 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+
+	"github.com/benjamw/golibs/hooks"
+)
+
+const (
+	// HookSaved is the prefix of the hooks fired after a model of a kind is saved: "db.saved.<Kind>"
+	HookSaved = "db.saved."
+
+	// HookDeleted is the prefix of the hooks fired after a model of a kind is deleted: "db.deleted.<Kind>"
+	HookDeleted = "db.deleted."
+
+	// OutboxKind is the kind of the durable change records written with ChangeOptions.Outbox
+	OutboxKind = "Outbox"
+
+	// maxXGGroups is the most entity groups a cross-group transaction can write to
+	maxXGGroups = 25
+)
+
+// Change describes a saved or deleted model
+// It is the parameter passed to the listeners of the change hooks
+type Change struct {
+	Op    string                 // OpSave or OpDelete
+	Kind  string                 // the kind of the model
+	Key   *datastore.Key         // the key of the model
+	Model Model                  // the saved or deleted model
+	Old   datastore.PropertyList // the stored properties before the change (with ChangeOptions.OldState, nil for new entities)
+	New   datastore.PropertyList // the properties after the change (nil for deletes)
+}
+
+// ChangeOptions configures the change capture for a kind
+type ChangeOptions struct {
+	// OldState loads the stored entity before every change so Change.Old gets set
+	// It costs a read for every write
+	OldState bool
+
+	// Outbox writes an OutboxEntry in the same transaction as every change, for reliable delivery
+	// When the context is the one of a transaction started with RunInTransaction the entries are written in it,
+	// otherwise SaveMulti writes the models in cross-group transactions of at most 25 entity groups each,
+	// so a failure can leave the transactions before it written
+	Outbox bool
+}
+
+// OutboxEntry is a durable record of a change, see PendingOutbox and AckOutbox
+// Its parent is the key of the changed model
+type OutboxEntry struct {
+	Key     *datastore.Key `datastore:"-"`
+	Op      string
+	Kind    string
+	Created time.Time
+	Data    []byte `datastore:",noindex"` // the JSON (see ExportEntity) of the stored entity, nil for deletes
+}
+
+var (
+	changesMu sync.RWMutex
+	changes   = make(map[string]ChangeOptions)
+)
+
+// CaptureChanges registers the change hooks for the given kind with the given options
+// Calling it again changes the options, and registers the hooks again if they were unregistered
+func CaptureChanges(kind string, opts ChangeOptions) {
+	changesMu.Lock()
+	defer changesMu.Unlock()
+
+	changes[kind] = opts
+
+	for _, h := range []string{HookSaved + kind, HookDeleted + kind} {
+		if !hooks.IsRegistered(h) {
+			hooks.NewHook[*Change](h)
+		}
+	}
+}
+
+// UncaptureChanges stops capturing the changes of the given kind
+// and unregisters its change hooks with all of their listeners
+func UncaptureChanges(kind string) {
+	changesMu.Lock()
+	defer changesMu.Unlock()
+
+	delete(changes, kind)
+
+	hooks.Unregister(HookSaved + kind)
+	hooks.Unregister(HookDeleted + kind)
+}
+
+// ListenSaved listens for saves of the given kind, capturing its changes if it isn't already
+// The listener can return hooks.ErrHalt to stop the listeners after it
+func ListenSaved(kind string, h func(context.Context, *Change) error, priority int) *hooks.Subscription {
+	return listenChanges(HookSaved, kind, h, priority)
+}
+
+// ListenDeleted listens for deletes of the given kind, capturing its changes if it isn't already
+// The listener can return hooks.ErrHalt to stop the listeners after it
+func ListenDeleted(kind string, h func(context.Context, *Change) error, priority int) *hooks.Subscription {
+	return listenChanges(HookDeleted, kind, h, priority)
+}
+
+func listenChanges(prefix string, kind string, h func(context.Context, *Change) error, priority int) *hooks.Subscription {
+	opts, ok := changeOptions(kind)
+	if hook, found := hooks.LookupHook[*Change](prefix + kind); ok && found {
+		return hook.Listen(h, priority)
+	}
+
+	// the kind isn't captured yet, or its hooks were unregistered
+	CaptureChanges(kind, opts)
+
+	hook, found := hooks.LookupHook[*Change](prefix + kind)
+	if !found {
+		panic(fmt.Sprintf("db: %s%s is registered as another type of hook", prefix, kind))
+	}
+
+	return hook.Listen(h, priority)
+}
+
+// changeOptions returns the change capture options of the given kind and whether it is captured
+func changeOptions(kind string) (opts ChangeOptions, ok bool) {
+	changesMu.RLock()
+	defer changesMu.RUnlock()
+
+	opts, ok = changes[kind]
+	return
+}
+
+// PendingOutbox returns up to limit of the oldest outbox entries
+func PendingOutbox(ctx context.Context, limit int) (entries []*OutboxEntry, myerr error) {
+	q := datastore.NewQuery(OutboxKind).Order("Created").Limit(limit)
+
+	keys, myerr := Query(ctx, q, &entries)
+	if myerr != nil {
+		return
+	}
+
+	for i, k := range keys {
+		entries[i].Key = k
+	}
+
+	return
+}
+
+// AckOutbox removes the delivered outbox entries
+func AckOutbox(ctx context.Context, entries []*OutboxEntry) error {
+	keys := make([]*datastore.Key, len(entries))
+	for i, e := range entries {
+		keys[i] = e.Key
+	}
+
+	return DeleteMultiK(ctx, keys)
+}
+
+// putModels puts the models with the given keys, capturing their changes
+// It returns the new keys and the captured changes (nil entries for models of kinds that aren't captured)
+func putModels(ctx context.Context, keys []*datastore.Key, models []Model) (newKeys []*datastore.Key, chs []*Change, myerr error) {
+	idempotent, outbox := true, false
+	opts := make([]*ChangeOptions, len(models))
+	for i, m := range models {
+		idempotent = idempotent && keys[i] != nil && !keys[i].Incomplete()
+
+		if o, ok := changeOptions(m.EntityType()); ok {
+			opts[i] = &o
+			outbox = outbox || o.Outbox
+		}
+	}
+
+	if !outbox {
+		myerr = retry(ctx, idempotent, func() (err error) {
+			newKeys, chs, err = putChanges(ctx, keys, models, opts)
+			return
+		})
+		return
+	}
+
+	newKeys = make([]*datastore.Key, len(models))
+	chs = make([]*Change, len(models))
+	for _, r := range groupChunks(keys, maxXGGroups) {
+		put := func(tc context.Context) error {
+			nk, cs, err := putChanges(tc, keys[r.start:r.end], models[r.start:r.end], opts[r.start:r.end])
+			if err != nil {
+				return err
+			}
+
+			copy(newKeys[r.start:], nk)
+			copy(chs[r.start:], cs)
+			return nil
+		}
+
+		if myerr = inTransaction(ctx, put, &datastore.TransactionOptions{XG: r.groups > 1}); myerr != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// putChanges puts the models with the given keys and writes the outbox entries of their changes
+func putChanges(ctx context.Context, keys []*datastore.Key, models []Model, opts []*ChangeOptions) (newKeys []*datastore.Key, chs []*Change, myerr error) {
+	old, myerr := oldStates(ctx, keys, models, opts)
+	if myerr != nil {
+		return
+	}
+
+	if len(keys) == 1 {
+		var k *datastore.Key
+		if k, myerr = datastore.Put(ctx, keys[0], wrap(ctx, models[0])); myerr != nil {
+			return
+		}
+		newKeys = []*datastore.Key{k}
+	} else if newKeys, myerr = datastore.PutMulti(ctx, keys, wrapMulti(ctx, models)); myerr != nil {
+		return
+	}
+
+	chs = make([]*Change, len(models))
+	for i, m := range models {
+		if opts[i] == nil {
+			continue
+		}
+
+		chs[i] = &Change{
+			Op:    OpSave,
+			Kind:  m.EntityType(),
+			Key:   newKeys[i],
+			Model: m,
+			Old:   old[i],
+		}
+		if chs[i].New, myerr = modelProperties(m); myerr != nil {
+			return
+		}
+	}
+
+	myerr = putOutbox(ctx, chs, opts)
+	return
+}
+
+// groupChunk is a range of keys that fits in one transaction
+type groupChunk struct {
+	start, end int
+	groups     int // the number of entity groups in the range
+}
+
+// groupChunks splits keys into ranges of at most max entity groups each
+// Every incomplete key without a parent is a new entity group.
+func groupChunks(keys []*datastore.Key, max int) (chunks []groupChunk) {
+	c := groupChunk{}
+	seen := make(map[string]bool)
+	for i, k := range keys {
+		group := ""
+		if k != nil {
+			for k.Parent() != nil {
+				k = k.Parent()
+			}
+			if !k.Incomplete() {
+				group = k.Encode()
+			}
+		}
+
+		if group != "" && seen[group] {
+			continue
+		}
+
+		if c.groups == max {
+			c.end = i
+			chunks = append(chunks, c)
+			c = groupChunk{start: i}
+			seen = make(map[string]bool)
+		}
+
+		c.groups++
+		if group != "" {
+			seen[group] = true
+		}
+	}
+
+	if len(keys) > 0 {
+		c.end = len(keys)
+		chunks = append(chunks, c)
+	}
+
+	return
+}
+
+// inTransaction runs f in a new transaction, or in the transaction of ctx if it is one started by db
+// datastore.RunInTransaction already retries concurrent transactions, so it isn't retried again
+func inTransaction(ctx context.Context, f func(tc context.Context) error, opts *datastore.TransactionOptions) error {
+	if isTransaction(ctx) {
+		return f(ctx)
+	}
+
+	return runInTransaction(ctx, f, opts)
+}
+
+// deleteModel deletes m, capturing the change
+func deleteModel(ctx context.Context, m Model) (ch *Change, myerr error) {
+	o, ok := changeOptions(m.EntityType())
+	if !ok {
+		myerr = retry(ctx, true, func() error {
+			return datastore.Delete(ctx, m.GetKey())
+		})
+		return
+	}
+
+	del := func(c context.Context) (err error) {
+		old, err := oldStates(c, []*datastore.Key{m.GetKey()}, []Model{m}, []*ChangeOptions{&o})
+		if err != nil {
+			return
+		}
+
+		if err = datastore.Delete(c, m.GetKey()); err != nil {
+			return
+		}
+
+		ch = &Change{
+			Op:    OpDelete,
+			Kind:  m.EntityType(),
+			Key:   m.GetKey(),
+			Model: m,
+			Old:   old[0],
+		}
+
+		if o.Outbox {
+			err = putOutbox(c, []*Change{ch}, []*ChangeOptions{&o})
+		}
+
+		return
+	}
+
+	if o.Outbox {
+		myerr = inTransaction(ctx, del, nil)
+	} else {
+		myerr = retry(ctx, true, func() error {
+			return del(ctx)
+		})
+	}
+
+	return
+}
+
+// fireChanges fires the change hook of every captured change
+func fireChanges(ctx context.Context, chs []*Change) {
+	for _, ch := range chs {
+		if ch == nil {
+			continue
+		}
+
+		hook := HookSaved + ch.Kind
+		if ch.Op == OpDelete {
+			hook = HookDeleted + ch.Kind
+		}
+
+		// the hook may have been unregistered since the kind was captured
+		if h, ok := hooks.LookupHook[*Change](hook); ok {
+			h.Do(ctx, ch)
+		}
+	}
+}
+
+// oldStates loads the stored, decrypted properties of the models that capture their old state
+func oldStates(ctx context.Context, keys []*datastore.Key, models []Model, opts []*ChangeOptions) (old []datastore.PropertyList, myerr error) {
+	old = make([]datastore.PropertyList, len(keys))
+
+	var getKeys []*datastore.Key
+	var idx []int
+	for i, k := range keys {
+		if opts[i] != nil && opts[i].OldState && k != nil && !k.Incomplete() {
+			getKeys = append(getKeys, k)
+			idx = append(idx, i)
+		}
+	}
+
+	if len(getKeys) == 0 {
+		return
+	}
+
+	list := make([]datastore.PropertyList, len(getKeys))
+	if myerr = datastore.GetMulti(ctx, getKeys, list); myerr != nil {
+		me, ok := myerr.(appengine.MultiError)
+		if !ok {
+			return
+		}
+
+		// new entities don't have an old state
+		for _, err := range me {
+			if err != nil && err != datastore.ErrNoSuchEntity {
+				myerr = err
+				return
+			}
+		}
+		myerr = nil
+	}
+
+	for j, i := range idx {
+		if list[j] == nil {
+			continue
+		}
+
+		if old[i], myerr = decryptProperties(ctx, models[i], list[j]); myerr != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// putOutbox writes an outbox entry for every change of a kind that uses the outbox
+func putOutbox(ctx context.Context, chs []*Change, opts []*ChangeOptions) (myerr error) {
+	var keys []*datastore.Key
+	var entries []*OutboxEntry
+	for i, ch := range chs {
+		if ch == nil || opts[i] == nil || !opts[i].Outbox {
+			continue
+		}
+
+		e := &OutboxEntry{
+			Op:      ch.Op,
+			Kind:    ch.Kind,
+			Created: time.Now(),
+		}
+
+		if ch.New != nil {
+			var pl []datastore.Property
+			if pl, myerr = encryptProperties(ctx, ch.Model, ch.New); myerr != nil {
+				return
+			}
+
+			var ee *ExportedEntity
+			if ee, myerr = ExportEntity(ch.Key, pl); myerr != nil {
+				return
+			}
+
+			if e.Data, myerr = json.Marshal(ee); myerr != nil {
+				return
+			}
+		}
+
+		keys = append(keys, datastore.NewIncompleteKey(ctx, OutboxKind, ch.Key))
+		entries = append(entries, e)
+	}
+
+	if len(keys) > 0 {
+		_, myerr = datastore.PutMulti(ctx, keys, entries)
+	}
+
+	return
+}
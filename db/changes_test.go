@@ -0,0 +1,275 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"google.golang.org/appengine/datastore"
+
+	"github.com/benjamw/golibs/hooks"
+)
+
+type Note struct {
+	base
+	Text string `datastore:",noindex"`
+}
+
+func (m *Note) EntityType() string {
+	return "Note"
+}
+
+func (m *Note) PreSave(c context.Context) error {
+	if m.GetKey() == nil {
+		m.SetIsNew(true)
+		m.SetKey(datastore.NewIncompleteKey(c, m.EntityType(), nil))
+	}
+	return nil
+}
+
+func TestChangeHooks(t *testing.T) {
+	defer ResetDB()
+	ctx := GetCtx()
+
+	defer UncaptureChanges("Note")
+
+	var saved, deleted []*Change
+	sub := ListenSaved("Note", func(ctx context.Context, c *Change) error {
+		saved = append(saved, c)
+		return nil
+	}, 0)
+	defer sub.Unlisten()
+	sub = ListenDeleted("Note", func(ctx context.Context, c *Change) error {
+		deleted = append(deleted, c)
+		return nil
+	}, 0)
+	defer sub.Unlisten()
+	CaptureChanges("Note", ChangeOptions{OldState: true, Outbox: true})
+
+	n := &Note{Text: "first"}
+	if err := Save(ctx, n); err != nil {
+		t.Fatalf("Save threw an error. Error: %v", err)
+	}
+
+	if len(saved) != 1 {
+		t.Fatalf("Save fired the wrong number of changes. Wanted: 1; Got: %d", len(saved))
+	}
+	if saved[0].Old != nil {
+		t.Fatalf("Save returned an old state for a new entity. Got: %v", saved[0].Old)
+	}
+	if !saved[0].Key.Equal(n.GetKey()) || changeText(saved[0].New) != "first" {
+		t.Fatalf("Save fired the wrong change. Got: %+v", saved[0])
+	}
+
+	n.Text = "second"
+	if err := SaveMulti(ctx, []Model{n}); err != nil {
+		t.Fatalf("SaveMulti threw an error. Error: %v", err)
+	}
+
+	if len(saved) != 2 {
+		t.Fatalf("SaveMulti fired the wrong number of changes. Wanted: 2; Got: %d", len(saved))
+	}
+	if changeText(saved[1].Old) != "first" || changeText(saved[1].New) != "second" {
+		t.Fatalf("SaveMulti fired the wrong change. Got: %+v", saved[1])
+	}
+
+	if err := Delete(ctx, n); err != nil {
+		t.Fatalf("Delete threw an error. Error: %v", err)
+	}
+
+	if len(deleted) != 1 || deleted[0].Op != OpDelete || changeText(deleted[0].Old) != "second" {
+		t.Fatalf("Delete fired the wrong change. Got: %+v", deleted)
+	}
+
+	// the outbox has an entry for every change, in order
+	// run an ancestor query to force the entries to be applied so they're available in the global query
+	if _, err := datastore.NewQuery(OutboxKind).Ancestor(n.GetKey()).KeysOnly().GetAll(ctx, nil); err != nil {
+		t.Fatalf("Could not query the outbox. Error: %v", err)
+	}
+
+	entries, err := PendingOutbox(ctx, 10)
+	if err != nil {
+		t.Fatalf("PendingOutbox threw an error. Error: %v", err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("PendingOutbox returned the wrong number of entries. Wanted: 3; Got: %d", len(entries))
+	}
+
+	ops := []string{OpSave, OpSave, OpDelete}
+	for i, e := range entries {
+		if e.Op != ops[i] || e.Kind != "Note" || !e.Key.Parent().Equal(n.GetKey()) {
+			t.Fatalf("PendingOutbox returned the wrong entry %d. Got: %+v", i, e)
+		}
+	}
+
+	var ee ExportedEntity
+	if err = json.Unmarshal(entries[1].Data, &ee); err != nil {
+		t.Fatalf("The outbox entry does not hold an exported entity. Error: %v", err)
+	}
+	if entries[2].Data != nil {
+		t.Fatal("The outbox entry of a delete holds data")
+	}
+
+	if err = AckOutbox(ctx, entries); err != nil {
+		t.Fatalf("AckOutbox threw an error. Error: %v", err)
+	}
+
+	if entries, err = PendingOutbox(ctx, 10); err != nil || len(entries) != 0 {
+		t.Fatalf("AckOutbox did not remove the entries. Error: %v; Got: %d", err, len(entries))
+	}
+}
+
+func TestChangeHooksUnregistered(t *testing.T) {
+	defer ResetDB()
+	ctx := GetCtx()
+
+	defer UncaptureChanges("Note")
+
+	CaptureChanges("Note", ChangeOptions{})
+	hooks.Unregister(HookSaved + "Note")
+
+	// saving doesn't fire the unregistered hook
+	n := &Note{Text: "first"}
+	if err := Save(ctx, n); err != nil {
+		t.Fatalf("Save threw an error. Error: %v", err)
+	}
+
+	// listening registers it again
+	var saved int
+	sub := ListenSaved("Note", func(ctx context.Context, c *Change) error {
+		saved++
+		return nil
+	}, 0)
+	defer sub.Unlisten()
+
+	n.Text = "second"
+	if err := Save(ctx, n); err != nil || saved != 1 {
+		t.Fatalf("Save did not fire the registered hook again. Error: %v; Got: %d", err, saved)
+	}
+
+	// capturing doesn't register a hook twice
+	CaptureChanges("Note", ChangeOptions{})
+}
+
+func TestUncaptureChanges(t *testing.T) {
+	defer ResetDB()
+	ctx := GetCtx()
+
+	var saved int
+	sub := ListenSaved("Note", func(ctx context.Context, c *Change) error {
+		saved++
+		return nil
+	}, 0)
+	defer sub.Unlisten()
+	CaptureChanges("Note", ChangeOptions{Outbox: true})
+
+	UncaptureChanges("Note")
+	if hooks.IsRegistered(HookSaved+"Note") || hooks.IsRegistered(HookDeleted+"Note") {
+		t.Fatal("UncaptureChanges did not unregister the change hooks")
+	}
+
+	n := &Note{Text: "first"}
+	if err := Save(ctx, n); err != nil || saved != 0 {
+		t.Fatalf("Save fired a change of a kind that is not captured. Error: %v; Got: %d", err, saved)
+	}
+
+	keys, err := datastore.NewQuery(OutboxKind).Ancestor(n.GetKey()).KeysOnly().GetAll(ctx, nil)
+	if err != nil || len(keys) != 0 {
+		t.Fatalf("Save wrote an outbox entry for a kind that is not captured. Error: %v; Got: %d", err, len(keys))
+	}
+}
+
+func TestOutboxGroups(t *testing.T) {
+	defer ResetDB()
+	ctx := GetCtx()
+
+	defer UncaptureChanges("Note")
+
+	CaptureChanges("Note", ChangeOptions{Outbox: true})
+
+	// more entity groups than a single cross-group transaction takes
+	models := make([]Model, maxXGGroups+5)
+	for i := range models {
+		models[i] = &Note{Text: "many"}
+	}
+
+	if err := SaveMulti(ctx, models); err != nil {
+		t.Fatalf("SaveMulti threw an error. Error: %v", err)
+	}
+
+	for _, m := range models {
+		keys, err := datastore.NewQuery(OutboxKind).Ancestor(m.GetKey()).KeysOnly().GetAll(ctx, nil)
+		if err != nil || len(keys) != 1 {
+			t.Fatalf("SaveMulti did not write the outbox entry. Error: %v; Got: %d", err, len(keys))
+		}
+	}
+}
+
+func TestOutboxInTransaction(t *testing.T) {
+	defer ResetDB()
+	ctx := GetCtx()
+
+	defer UncaptureChanges("Note")
+
+	CaptureChanges("Note", ChangeOptions{Outbox: true})
+
+	n := &Note{Text: "first"}
+	if err := Save(ctx, n); err != nil {
+		t.Fatalf("Save threw an error. Error: %v", err)
+	}
+
+	// the outbox entry is written in the transaction of the caller
+	err := RunInTransaction(ctx, func(tc context.Context) error {
+		n.Text = "second"
+		if err := Save(tc, n); err != nil {
+			return err
+		}
+
+		return Delete(tc, n)
+	}, nil)
+	if err != nil {
+		t.Fatalf("Save threw an error in a transaction. Error: %v", err)
+	}
+
+	keys, err := datastore.NewQuery(OutboxKind).Ancestor(n.GetKey()).KeysOnly().GetAll(ctx, nil)
+	if err != nil || len(keys) != 3 {
+		t.Fatalf("Save did not write the outbox entries in the transaction. Error: %v; Got: %d", err, len(keys))
+	}
+}
+
+func TestGroupChunks(t *testing.T) {
+	ctx := GetCtx()
+
+	root := datastore.NewKey(ctx, "Note", "", 1, nil)
+	keys := []*datastore.Key{
+		datastore.NewIncompleteKey(ctx, "Note", nil),
+		root,
+		datastore.NewKey(ctx, "Note", "", 2, root),
+		datastore.NewIncompleteKey(ctx, "Note", root),
+		datastore.NewIncompleteKey(ctx, "Note", nil),
+		datastore.NewKey(ctx, "Note", "", 3, nil),
+	}
+
+	got := groupChunks(keys, 2)
+	want := []groupChunk{{0, 4, 2}, {4, 6, 2}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("groupChunks returned the wrong chunks. Wanted: %v; Got: %v", want, got)
+	}
+
+	if got = groupChunks(nil, 2); got != nil {
+		t.Fatalf("groupChunks returned chunks for no keys. Got: %v", got)
+	}
+}
+
+// changeText returns the Text property of the given properties
+func changeText(pl datastore.PropertyList) string {
+	for _, p := range pl {
+		if p.Name == "Text" {
+			s, _ := p.Value.(string)
+			return s
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,117 @@
+package db
+
+import (
+	"context"
+	"reflect"
+
+	"google.golang.org/appengine/datastore"
+)
+
+// codec stands in for a Model when it gets handed to the datastore
+// so its properties can be processed on their way in and out
+type codec struct {
+	ctx context.Context
+	m   Model
+}
+
+// Load satisfies the datastore.PropertyLoadSaver interface
+func (c *codec) Load(pl []datastore.Property) (myerr error) {
+	if pl, myerr = decryptProperties(c.ctx, c.m, pl); myerr != nil {
+		return
+	}
+
+	if pls, ok := c.m.(datastore.PropertyLoadSaver); ok {
+		return pls.Load(pl)
+	}
+
+	return datastore.LoadStruct(c.m, pl)
+}
+
+// Save satisfies the datastore.PropertyLoadSaver interface
+func (c *codec) Save() (pl []datastore.Property, myerr error) {
+	if pl, myerr = modelProperties(c.m); myerr != nil {
+		return
+	}
+
+	return encryptProperties(c.ctx, c.m, pl)
+}
+
+// needsCodec reports whether m has to be wrapped in a codec
+func needsCodec(m interface{}) bool {
+	return len(taggedFields(m, tagEncrypted)) > 0
+}
+
+// wrap returns what gets handed to the datastore in place of m
+func wrap(ctx context.Context, m Model) interface{} {
+	if !needsCodec(m) {
+		return m
+	}
+
+	return &codec{ctx: ctx, m: m}
+}
+
+// wrapMulti returns what gets handed to the datastore in place of models
+func wrapMulti(ctx context.Context, models []Model) interface{} {
+	wrapped := false
+	dst := make([]interface{}, len(models))
+	for i, m := range models {
+		dst[i] = wrap(ctx, m)
+		if _, ok := dst[i].(*codec); ok {
+			wrapped = true
+		}
+	}
+
+	if !wrapped {
+		return models
+	}
+
+	return dst
+}
+
+// getAll runs q.GetAll, loading the results through a codec when the elements of dst need one
+func getAll(ctx context.Context, q *datastore.Query, dst interface{}) (keys []*datastore.Key, myerr error) {
+	v := reflect.ValueOf(dst)
+	if dst == nil || v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Slice {
+		return q.GetAll(ctx, dst)
+	}
+
+	v = v.Elem()
+	et := v.Type().Elem()
+	isPtr := et.Kind() == reflect.Ptr
+	st := et
+	if isPtr {
+		st = et.Elem()
+	}
+
+	if st.Kind() != reflect.Struct || !reflect.PtrTo(st).Implements(typeOfModel) || !needsCodec(reflect.New(st).Interface()) {
+		return q.GetAll(ctx, dst)
+	}
+
+	var pls []datastore.PropertyList
+	if keys, myerr = q.GetAll(ctx, &pls); myerr != nil {
+		return
+	}
+
+	for _, pl := range pls {
+		e := reflect.New(st)
+		// like GetAll, a field mismatch doesn't stop the loading, but does get returned
+		if err := (&codec{ctx: ctx, m: e.Interface().(Model)}).Load(pl); err != nil {
+			if !IsFieldMismatch(err) {
+				myerr = err
+				return
+			}
+			if myerr == nil {
+				myerr = err
+			}
+		}
+
+		if !isPtr {
+			e = e.Elem()
+		}
+		v.Set(reflect.Append(v, e))
+	}
+
+	return
+}
+
+var typeOfModel = reflect.TypeOf((*Model)(nil)).Elem()
@@ -0,0 +1,201 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/memcache"
+
+	"github.com/benjamw/golibs/random"
+)
+
+const (
+	counterConfigKind = "CounterConfig"
+	counterShardKind  = "CounterShard"
+
+	// DefaultCounterShards is the number of shards used when a Counter does not specify any
+	DefaultCounterShards = 20
+)
+
+// Counter is a sharded counter
+//
+// Writing to a single entity is limited to about one write per second, so a Counter
+// spreads its increments across several shard entities and sums them when read.
+type Counter struct {
+	Name   string        // the unique name of the counter
+	Shards int           // the minimum number of shards to spread writes across
+	Cache  time.Duration // how long the summed count is cached in memcache (0 disables caching)
+}
+
+type counterConfig struct {
+	Shards int `datastore:",noindex"`
+}
+
+type counterShard struct {
+	Name  string
+	Count int64 `datastore:",noindex"`
+}
+
+// NewCounter returns a Counter with the given name and minimum number of shards
+func NewCounter(name string, shards int) *Counter {
+	if shards <= 0 {
+		shards = DefaultCounterShards
+	}
+
+	return &Counter{
+		Name:   name,
+		Shards: shards,
+	}
+}
+
+// Increment adds one to the counter
+func (c *Counter) Increment(ctx context.Context) error {
+	return c.IncrementBy(ctx, 1)
+}
+
+// IncrementBy adds delta to the counter by updating a random shard in a transaction
+func (c *Counter) IncrementBy(ctx context.Context, delta int64) (myerr error) {
+	shards, myerr := c.shardCount(ctx)
+	if myerr != nil {
+		return
+	}
+
+	k := c.shardKey(ctx, int(random.Intn(0, int64(shards-1))))
+	myerr = datastore.RunInTransaction(ctx, func(tc context.Context) error {
+		var s counterShard
+		if err := datastore.Get(tc, k, &s); err != nil && err != datastore.ErrNoSuchEntity {
+			return err
+		}
+
+		s.Name = c.Name
+		s.Count += delta
+
+		_, err := datastore.Put(tc, k, &s)
+		return err
+	}, nil)
+	if myerr != nil {
+		return
+	}
+
+	if c.Cache > 0 {
+		// a missing cache entry is fine, the next Count will fill it
+		if _, err := memcache.IncrementExisting(ctx, c.cacheKey(), delta); err != nil && err != memcache.ErrCacheMiss {
+			memcache.Delete(ctx, c.cacheKey())
+		}
+	}
+
+	return
+}
+
+// Count returns the current value of the counter
+func (c *Counter) Count(ctx context.Context) (count int64, myerr error) {
+	if c.Cache > 0 {
+		if item, err := memcache.Get(ctx, c.cacheKey()); err == nil {
+			if count, err = strconv.ParseInt(string(item.Value), 10, 64); err == nil {
+				return
+			}
+		}
+	}
+
+	count = 0
+
+	shards, myerr := c.shardCount(ctx)
+	if myerr != nil {
+		return
+	}
+
+	keys := make([]*datastore.Key, shards)
+	for i := range keys {
+		keys[i] = c.shardKey(ctx, i)
+	}
+
+	list := make([]counterShard, shards)
+	if myerr = datastore.GetMulti(ctx, keys, list); myerr != nil {
+		me, ok := myerr.(appengine.MultiError)
+		if !ok {
+			return
+		}
+
+		// shards that have never been incremented don't exist yet
+		for _, err := range me {
+			if err != nil && err != datastore.ErrNoSuchEntity {
+				myerr = err
+				return
+			}
+		}
+		myerr = nil
+	}
+
+	for _, s := range list {
+		count += s.Count
+	}
+
+	if c.Cache > 0 {
+		memcache.Set(ctx, &memcache.Item{
+			Key:        c.cacheKey(),
+			Value:      []byte(strconv.FormatInt(count, 10)),
+			Expiration: c.Cache,
+		})
+	}
+
+	return
+}
+
+// IncreaseShards grows the number of shards for the counter to n
+// The number of shards never shrinks, so an n lower than the current count does nothing
+func (c *Counter) IncreaseShards(ctx context.Context, n int) (myerr error) {
+	k := datastore.NewKey(ctx, counterConfigKind, c.Name, 0, nil)
+	myerr = datastore.RunInTransaction(ctx, func(tc context.Context) error {
+		var cfg counterConfig
+		if err := datastore.Get(tc, k, &cfg); err != nil && err != datastore.ErrNoSuchEntity {
+			return err
+		}
+
+		if cfg.Shards >= n {
+			return nil
+		}
+
+		cfg.Shards = n
+		_, err := datastore.Put(tc, k, &cfg)
+		return err
+	}, nil)
+
+	return
+}
+
+// shardCount returns the number of stored shards, making sure it is at least c.Shards
+func (c *Counter) shardCount(ctx context.Context) (shards int, myerr error) {
+	var cfg counterConfig
+	k := datastore.NewKey(ctx, counterConfigKind, c.Name, 0, nil)
+	if myerr = datastore.Get(ctx, k, &cfg); myerr != nil && myerr != datastore.ErrNoSuchEntity {
+		return
+	}
+
+	min := c.Shards
+	if min <= 0 {
+		min = DefaultCounterShards
+	}
+
+	if cfg.Shards < min {
+		if myerr = c.IncreaseShards(ctx, min); myerr != nil {
+			return
+		}
+		cfg.Shards = min
+	}
+
+	shards = cfg.Shards
+	myerr = nil
+	return
+}
+
+func (c *Counter) shardKey(ctx context.Context, i int) *datastore.Key {
+	return datastore.NewKey(ctx, counterShardKind, fmt.Sprintf("%s-shard%d", c.Name, i), 0, nil)
+}
+
+func (c *Counter) cacheKey() string {
+	return "db.Counter:" + c.Name
+}
@@ -0,0 +1,113 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCounter(t *testing.T) {
+	defer ResetDB()
+	ctx := GetCtx()
+
+	c := NewCounter("TestCounter", 5)
+
+	count, err := c.Count(ctx)
+	if err != nil {
+		t.Fatalf("Count threw an error on an empty counter. Error: %v", err)
+	}
+	if count != 0 {
+		t.Fatalf("Count returned the wrong value for an empty counter. Wanted: 0; Got: %d", count)
+	}
+
+	for i := 0; i < 10; i++ {
+		if err = c.Increment(ctx); err != nil {
+			t.Fatalf("Increment threw an error. Error: %v", err)
+		}
+	}
+
+	if err = c.IncrementBy(ctx, 5); err != nil {
+		t.Fatalf("IncrementBy threw an error. Error: %v", err)
+	}
+
+	count, err = c.Count(ctx)
+	if err != nil {
+		t.Fatalf("Count threw an error. Error: %v", err)
+	}
+	if count != 15 {
+		t.Fatalf("Count returned the wrong value. Wanted: 15; Got: %d", count)
+	}
+}
+
+func TestCounterIncreaseShards(t *testing.T) {
+	defer ResetDB()
+	ctx := GetCtx()
+
+	c := NewCounter("TestCounterIncreaseShards", 2)
+
+	for i := 0; i < 5; i++ {
+		if err := c.Increment(ctx); err != nil {
+			t.Fatalf("Increment threw an error. Error: %v", err)
+		}
+	}
+
+	if err := c.IncreaseShards(ctx, 10); err != nil {
+		t.Fatalf("IncreaseShards threw an error. Error: %v", err)
+	}
+
+	// shrinking should do nothing
+	if err := c.IncreaseShards(ctx, 1); err != nil {
+		t.Fatalf("IncreaseShards threw an error when shrinking. Error: %v", err)
+	}
+
+	shards, err := c.shardCount(ctx)
+	if err != nil {
+		t.Fatalf("shardCount threw an error. Error: %v", err)
+	}
+	if shards != 10 {
+		t.Fatalf("IncreaseShards did not grow the counter. Wanted: 10; Got: %d", shards)
+	}
+
+	for i := 0; i < 5; i++ {
+		if err := c.Increment(ctx); err != nil {
+			t.Fatalf("Increment threw an error. Error: %v", err)
+		}
+	}
+
+	// a counter with fewer shards still reads every stored shard
+	count, err := NewCounter("TestCounterIncreaseShards", 1).Count(ctx)
+	if err != nil {
+		t.Fatalf("Count threw an error. Error: %v", err)
+	}
+	if count != 10 {
+		t.Fatalf("Count returned the wrong value. Wanted: 10; Got: %d", count)
+	}
+}
+
+func TestCounterCache(t *testing.T) {
+	defer ResetDB()
+	ctx := GetCtx()
+
+	c := NewCounter("TestCounterCache", 3)
+	c.Cache = time.Minute
+
+	if err := c.IncrementBy(ctx, 3); err != nil {
+		t.Fatalf("IncrementBy threw an error. Error: %v", err)
+	}
+
+	// fill the cache
+	if _, err := c.Count(ctx); err != nil {
+		t.Fatalf("Count threw an error. Error: %v", err)
+	}
+
+	if err := c.IncrementBy(ctx, 4); err != nil {
+		t.Fatalf("IncrementBy threw an error. Error: %v", err)
+	}
+
+	count, err := c.Count(ctx)
+	if err != nil {
+		t.Fatalf("Count threw an error. Error: %v", err)
+	}
+	if count != 7 {
+		t.Fatalf("Count returned the wrong cached value. Wanted: 7; Got: %d", count)
+	}
+}
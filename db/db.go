@@ -2,10 +2,13 @@ package db
 
 import (
 	"context"
+	"errors"
+	"log/slog"
+	"reflect"
 	"strings"
 
+	"google.golang.org/appengine"
 	"google.golang.org/appengine/datastore"
-	"google.golang.org/appengine/log"
 )
 
 type Model interface {
@@ -20,21 +23,36 @@ type Model interface {
 }
 
 func Load(ctx context.Context, k *datastore.Key, m Model) (found bool, myerr error) {
+	ctx, op := startOp(ctx, OpLoad, m.EntityType())
+	defer func() {
+		n := 0
+		if found {
+			n = 1
+		}
+		op.end(n, myerr)
+	}()
+
 	found = false
 
-	if myerr = datastore.Get(ctx, k, m); myerr != nil {
-		if ErrFieldMismatch(ctx, myerr, k, m) != nil {
-			myerr = &UnfoundObjectError{
-				EntityType: m.EntityType(),
-				Key:        "key",
-				Value:      k.Encode(),
-				Err:        myerr,
+	myerr = retry(ctx, true, func() error {
+		return datastore.Get(ctx, k, wrap(ctx, m))
+	})
+	if myerr != nil {
+		if myerr = ErrFieldMismatch(ctx, myerr, k, m); myerr != nil {
+			if isNotFound(myerr) {
+				myerr = &UnfoundObjectError{
+					EntityType: m.EntityType(),
+					Key:        "key",
+					Value:      k.Encode(),
+					Err:        myerr,
+				}
 			}
 			return
 		}
 	}
 
 	found = true
+	loadedFully(m)
 
 	if myerr = m.SetKey(k); myerr != nil {
 		return
@@ -44,6 +62,10 @@ func Load(ctx context.Context, k *datastore.Key, m Model) (found bool, myerr err
 		return
 	}
 
+	if myerr = snapshot(m); myerr != nil {
+		return
+	}
+
 	return
 }
 
@@ -52,8 +74,7 @@ func LoadS(ctx context.Context, sk string, m Model) (found bool, myerr error) {
 
 	newKey, myerr := datastore.DecodeKey(sk)
 	if myerr != nil {
-		log.Infof(ctx, "LoadS Key: %s", sk)
-		log.Infof(ctx, "LoadS Err: %v", myerr)
+		getLogger().LogAttrs(ctx, slog.LevelInfo, "LoadS failed to decode key", slog.String("key", sk), slog.Any("error", myerr))
 		return
 	}
 
@@ -69,15 +90,35 @@ func LoadInt(ctx context.Context, id int64, m Model) (found bool, myerr error) {
 }
 
 func LoadMulti(ctx context.Context, keys []*datastore.Key, models []Model) (found int, myerr error) {
+	ctx, op := startOp(ctx, OpLoadMulti, keysKind(keys))
+	defer func() { op.end(found, myerr) }()
+
 	found = 0
 
-	if myerr = datastore.GetMulti(ctx, keys, models); myerr != nil {
-		if ErrFieldMismatchMulti(ctx, myerr, keys, models) != nil {
+	myerr = retry(ctx, true, func() error {
+		return datastore.GetMulti(ctx, keys, wrapMulti(ctx, models))
+	})
+	if myerr != nil {
+		if myerr = ErrFieldMismatchMulti(ctx, myerr, keys, models); myerr != nil {
+			if me, ok := myerr.(appengine.MultiError); ok {
+				myerr = &MultiError{Errs: me}
+			}
+
+			if isNotFound(myerr) {
+				myerr = &UnfoundObjectError{
+					EntityType: keysKind(keys),
+					Key:        "keys",
+					Value:      missingKeys(keys, myerr),
+					Err:        myerr,
+				}
+			}
 			return
 		}
 	}
 
 	for i, k := range keys {
+		loadedFully(models[i])
+
 		if myerr = models[i].SetKey(k); myerr != nil {
 			return
 		}
@@ -85,6 +126,10 @@ func LoadMulti(ctx context.Context, keys []*datastore.Key, models []Model) (foun
 		if myerr = models[i].PostLoad(ctx); myerr != nil {
 			return
 		}
+
+		if myerr = snapshot(models[i]); myerr != nil {
+			return
+		}
 	}
 
 	found = len(models)
@@ -118,17 +163,42 @@ func LoadMultiInt(ctx context.Context, intKeys []int64, entityType string, model
 }
 
 func Save(ctx context.Context, m Model) (myerr error) {
+	n := 0
+	ctx, op := startOp(ctx, OpSave, m.EntityType())
+	defer func() { op.end(n, myerr) }()
+
+	if myerr = checkPartial(m); myerr != nil {
+		return
+	}
+
+	if _, myerr = trackChanges(m); myerr != nil {
+		return
+	}
+
 	if myerr = m.PreSave(ctx); myerr != nil {
 		return
 	}
 
-	newKey, myerr := datastore.Put(ctx, m.GetKey(), m)
+	changed, myerr := trackChanges(m)
+	if myerr != nil || !changed {
+		return
+	}
+
+	newKeys, chs, myerr := putModels(ctx, []*datastore.Key{m.GetKey()}, []Model{m})
 	if myerr != nil {
-		log.Infof(ctx, "Save Err: %v", myerr)
 		return
 	}
+	n = 1
 
-	if myerr = m.SetKey(newKey); myerr != nil {
+	if myerr = m.SetKey(newKeys[0]); myerr != nil {
+		return
+	}
+
+	if myerr = updateIndexes(ctx, []Model{m}); myerr != nil {
+		return
+	}
+
+	if myerr = resnapshot(m); myerr != nil {
 		return
 	}
 
@@ -136,51 +206,107 @@ func Save(ctx context.Context, m Model) (myerr error) {
 		return
 	}
 
+	fireChanges(ctx, chs)
+
 	return
 }
 
 func SaveMulti(ctx context.Context, models []Model) (myerr error) {
-	var keys []*datastore.Key
+	n := 0
+	ctx, op := startOp(ctx, OpSaveMulti, modelsKind(models))
+	defer func() { op.end(n, myerr) }()
+
+	for i := range models {
+		if myerr = checkPartial(models[i]); myerr != nil {
+			return
+		}
+
+		if _, myerr = trackChanges(models[i]); myerr != nil {
+			return
+		}
+	}
+
 	for i := range models {
 		if myerr = models[i].PreSave(ctx); myerr != nil {
 			return
 		}
-		keys = append(keys, models[i].GetKey())
 	}
 
-	newKeys, myerr := datastore.PutMulti(ctx, keys, models)
+	// only put the models that changed
+	var keys []*datastore.Key
+	var changed []Model
+	for i := range models {
+		var c bool
+		if c, myerr = trackChanges(models[i]); myerr != nil {
+			return
+		}
+
+		if c {
+			keys = append(keys, models[i].GetKey())
+			changed = append(changed, models[i])
+		}
+	}
+
+	if len(changed) == 0 {
+		return
+	}
+
+	newKeys, chs, myerr := putModels(ctx, keys, changed)
 	if myerr != nil {
-		log.Infof(ctx, "SaveMulti Err: %v", myerr)
 		return
 	}
+	n = len(newKeys)
 
-	for i := range models {
-		if myerr = models[i].SetKey(newKeys[i]); myerr != nil {
+	for i := range changed {
+		if myerr = changed[i].SetKey(newKeys[i]); myerr != nil {
+			return
+		}
+	}
+
+	if myerr = updateIndexes(ctx, changed); myerr != nil {
+		return
+	}
+
+	for i := range changed {
+		if myerr = resnapshot(changed[i]); myerr != nil {
 			return
 		}
 
-		if myerr = models[i].PostSave(ctx); myerr != nil {
+		if myerr = changed[i].PostSave(ctx); myerr != nil {
 			return
 		}
 	}
 
+	fireChanges(ctx, chs)
+
 	return
 }
 
 func Delete(ctx context.Context, m Model) (myerr error) {
+	n := 0
+	ctx, op := startOp(ctx, OpDelete, m.EntityType())
+	defer func() { op.end(n, myerr) }()
+
 	if myerr = m.PreDelete(ctx); myerr != nil {
-		log.Infof(ctx, "PreDelete Err: %v", myerr)
 		return
 	}
 
-	if myerr = datastore.Delete(ctx, m.GetKey()); myerr != nil {
-		log.Infof(ctx, "Delete Err: %v", myerr)
+	ch, myerr := deleteModel(ctx, m)
+	if myerr != nil {
+		return
+	}
+	n = 1
+
+	forget(m)
+
+	if myerr = removeIndexes(ctx, m); myerr != nil {
 		return
 	}
 
+	fireChanges(ctx, []*Change{ch})
+
 	// if and when PostDelete gets built and/or is needed...
 	//if myerr = m.PostDelete(ctx); myerr != nil {
-	//	log.Infof(ctx, "PostDelete Err: %v", myerr)
 	//	return
 	//}
 
@@ -188,6 +314,13 @@ func Delete(ctx context.Context, m Model) (myerr error) {
 }
 
 func DeleteMultiK(ctx context.Context, keys []*datastore.Key) (myerr error) {
+	deletedN := 0
+	ctx, op := startOp(ctx, OpDeleteMulti, keysKind(keys))
+	defer func() { op.end(deletedN, myerr) }()
+
+	// the loop below uses up keys
+	deleted := append([]*datastore.Key(nil), keys...)
+
 	// the datastore has a query limit, so chunk the query into small enough
 	// chunks as to not trip the query limit error
 	// chunk it into 0.5MB sizes to prevent query limit
@@ -209,71 +342,218 @@ func DeleteMultiK(ctx context.Context, keys []*datastore.Key) (myerr error) {
 			keys = append(keys[:k-n], keys[k-n+1:]...)
 		}
 
-		if myerr = datastore.DeleteMulti(ctx, chunk); myerr != nil {
+		myerr = retry(ctx, true, func() error {
+			return datastore.DeleteMulti(ctx, chunk)
+		})
+		if myerr != nil {
 			return
 		}
+		deletedN += len(chunk)
 	}
 
+	myerr = removeIndexesK(ctx, deleted)
+
 	return
 }
 
-func ErrFieldMismatchMulti(ctx context.Context, err error, keys []*datastore.Key, models []Model) (myerr error) {
-	myerr, ok := err.(*datastore.ErrFieldMismatch)
-	if ok || strings.Contains(err.Error(), "datastore: cannot load field") {
-		tList := make([]datastore.PropertyList, len(keys))
-		myerr = datastore.GetMulti(ctx, keys, tList)
-		if myerr != nil {
+// Query runs q and loads the results into dst, which must be a valid dst for datastore.Query.GetAll
+// If the elements of dst are Models, their keys get set and PostLoad gets called
+func Query(ctx context.Context, q *datastore.Query, dst interface{}) (keys []*datastore.Key, myerr error) {
+	ctx, op := startOp(ctx, OpQuery, "")
+	defer func() {
+		op.kind = keysKind(keys)
+		op.end(len(keys), myerr)
+	}()
+
+	myerr = retry(ctx, true, func() (err error) {
+		// GetAll appends to dst, so clear out anything a failed attempt loaded
+		if v := reflect.ValueOf(dst); dst != nil && v.Kind() == reflect.Ptr && v.Elem().Kind() == reflect.Slice {
+			v.Elem().SetLen(0)
+		}
+
+		keys, err = getAll(ctx, q, dst)
+		return
+	})
+
+	models := queryModels(dst)
+	if myerr != nil {
+		if !IsFieldMismatch(myerr) || models == nil {
 			return
 		}
-		for i, propList := range tList {
-			myerr = models[i].SetKey(keys[i])
-			if myerr != nil {
-				return
-			}
-			myerr = models[i].Transform(ctx, propList)
-			if myerr != nil {
-				return
-			}
+
+		if myerr = ErrFieldMismatchOnQuery(ctx, myerr, keys, models); myerr != nil {
+			return
 		}
 	}
+
+	for i, m := range models {
+		loadedFully(m)
+
+		if myerr = m.SetKey(keys[i]); myerr != nil {
+			return
+		}
+
+		if myerr = m.PostLoad(ctx); myerr != nil {
+			return
+		}
+
+		if myerr = snapshot(m); myerr != nil {
+			return
+		}
+	}
+
 	return
 }
 
-func ErrFieldMismatchOnQuery(ctx context.Context, err error, keys []*datastore.Key, models []Model) (myerr error) {
-	myerr, ok := err.(*datastore.ErrFieldMismatch)
-	if ok || strings.ContainsAny(err.Error(), "datastore: cannot load field") {
-		tList := make([]datastore.PropertyList, len(keys))
-		myerr = datastore.GetMulti(ctx, keys, tList)
+// missingKeys returns the encoded keys the given appengine.MultiError reports as not found
+func missingKeys(keys []*datastore.Key, err error) string {
+	var me appengine.MultiError
+	if !errors.As(err, &me) {
+		return ""
+	}
+
+	var missing []string
+	for i, e := range me {
+		if isNotFound(e) && i < len(keys) {
+			missing = append(missing, keys[i].Encode())
+		}
+	}
+
+	return strings.Join(missing, ",")
+}
+
+// queryModels returns the elements of dst (a pointer to a slice) as Models,
+// or nil if they are not Models
+func queryModels(dst interface{}) []Model {
+	if dst == nil {
+		return nil
+	}
+
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Slice {
+		return nil
+	}
+	v = v.Elem()
+
+	models := make([]Model, v.Len())
+	for i := range models {
+		e := v.Index(i)
+		if e.Kind() != reflect.Ptr && e.Kind() != reflect.Interface {
+			e = e.Addr()
+		}
+
+		m, ok := e.Interface().(Model)
+		if !ok {
+			return nil
+		}
+		models[i] = m
+	}
+
+	return models
+}
+
+// keysKind returns the kind shared by all the keys, or an empty string if there is more than one
+func keysKind(keys []*datastore.Key) (kind string) {
+	seen := false
+	for _, k := range keys {
+		if k == nil {
+			continue
+		}
+
+		if seen && kind != k.Kind() {
+			return ""
+		}
+		kind = k.Kind()
+		seen = true
+	}
+
+	return
+}
+
+// modelsKind returns the kind shared by all the models, or an empty string if there is more than one
+func modelsKind(models []Model) (kind string) {
+	for i, m := range models {
+		if i > 0 && kind != m.EntityType() {
+			return ""
+		}
+		kind = m.EntityType()
+	}
+
+	return
+}
+
+func ErrFieldMismatchMulti(ctx context.Context, err error, keys []*datastore.Key, models []Model) (myerr error) {
+	if !IsFieldMismatch(err) {
+		return err
+	}
+
+	tList := make([]datastore.PropertyList, len(keys))
+	myerr = datastore.GetMulti(ctx, keys, tList)
+	if myerr != nil {
+		return
+	}
+	for i, propList := range tList {
+		myerr = models[i].SetKey(keys[i])
 		if myerr != nil {
 			return
 		}
-		for i, propList := range tList {
-			myerr = models[i].SetKey(keys[i])
-			if myerr != nil {
-				return
-			}
-			myerr = models[i].Transform(ctx, propList)
-			if myerr != nil {
-				return
-			}
+		if propList, myerr = decryptProperties(ctx, models[i], propList); myerr != nil {
+			return
+		}
+		myerr = models[i].Transform(ctx, propList)
+		if myerr != nil {
+			return
 		}
 	}
+
 	return
 }
 
-func ErrFieldMismatch(ctx context.Context, err error, k *datastore.Key, m Model) (myerr error) {
-	myerr, ok := err.(*datastore.ErrFieldMismatch)
-	if ok || strings.ContainsAny(err.Error(), "datastore: cannot load field") {
-		var propList datastore.PropertyList
-		myerr = datastore.Get(ctx, k, &propList)
+func ErrFieldMismatchOnQuery(ctx context.Context, err error, keys []*datastore.Key, models []Model) (myerr error) {
+	if !IsFieldMismatch(err) {
+		return err
+	}
+
+	tList := make([]datastore.PropertyList, len(keys))
+	myerr = datastore.GetMulti(ctx, keys, tList)
+	if myerr != nil {
+		return
+	}
+	for i, propList := range tList {
+		myerr = models[i].SetKey(keys[i])
 		if myerr != nil {
 			return
 		}
-		myerr = m.SetKey(k)
+		if propList, myerr = decryptProperties(ctx, models[i], propList); myerr != nil {
+			return
+		}
+		myerr = models[i].Transform(ctx, propList)
 		if myerr != nil {
 			return
 		}
-		myerr = m.Transform(ctx, propList)
 	}
+
+	return
+}
+
+func ErrFieldMismatch(ctx context.Context, err error, k *datastore.Key, m Model) (myerr error) {
+	if !IsFieldMismatch(err) {
+		return err
+	}
+
+	var propList datastore.PropertyList
+	myerr = datastore.Get(ctx, k, &propList)
+	if myerr != nil {
+		return
+	}
+	myerr = m.SetKey(k)
+	if myerr != nil {
+		return
+	}
+	if propList, myerr = decryptProperties(ctx, m, propList); myerr != nil {
+		return
+	}
+	myerr = m.Transform(ctx, propList)
+
 	return
 }
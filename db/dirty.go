@@ -0,0 +1,147 @@
+package db
+
+import (
+	"reflect"
+	"sort"
+
+	"google.golang.org/appengine/datastore"
+)
+
+// TrackedModel is a Model that keeps a snapshot of its properties as they were loaded,
+// so Save and SaveMulti can tell which properties changed
+//
+// Before PreSave and again after it, the names of the changed properties are set with SetChanged,
+// so PreSave and PostSave can react to them. A loaded model without changes is not put at all,
+// and neither PostSave nor the change hooks get called for it.
+// A model without a snapshot (a new or deleted one) has every property changed,
+// and a model whose key is not the one of its snapshot (a copied or moved one) always gets put.
+type TrackedModel interface {
+	Model
+	Snapshot() datastore.PropertyList
+	SnapshotKey() *datastore.Key
+	SetSnapshot(*datastore.Key, datastore.PropertyList)
+	Changed() []string
+	SetChanged([]string)
+}
+
+// Tracked implements the TrackedModel methods, embed it in a model with a `datastore:"-"` tag
+type Tracked struct {
+	snapshot    datastore.PropertyList
+	snapshotKey *datastore.Key
+	changed     []string
+}
+
+func (t *Tracked) Snapshot() datastore.PropertyList {
+	return t.snapshot
+}
+
+func (t *Tracked) SnapshotKey() *datastore.Key {
+	return t.snapshotKey
+}
+
+func (t *Tracked) SetSnapshot(k *datastore.Key, pl datastore.PropertyList) {
+	t.snapshotKey = k
+	t.snapshot = pl
+}
+
+func (t *Tracked) Changed() []string {
+	return t.changed
+}
+
+func (t *Tracked) SetChanged(names []string) {
+	t.changed = names
+}
+
+// IsChanged reports whether the named property changed
+func (t *Tracked) IsChanged(name string) bool {
+	return hasProperty(t.changed, name)
+}
+
+// snapshot stores the current properties of a loaded TrackedModel and clears its changes
+func snapshot(m Model) (myerr error) {
+	tm, ok := m.(TrackedModel)
+	if !ok {
+		return
+	}
+
+	pl, myerr := modelProperties(m)
+	if myerr != nil {
+		return
+	}
+
+	tm.SetSnapshot(m.GetKey(), pl)
+	tm.SetChanged(nil)
+	return
+}
+
+// resnapshot stores the current properties of a saved TrackedModel, keeping its changes
+func resnapshot(m Model) (myerr error) {
+	tm, ok := m.(TrackedModel)
+	if !ok {
+		return
+	}
+
+	pl, myerr := modelProperties(m)
+	if myerr != nil {
+		return
+	}
+
+	tm.SetSnapshot(m.GetKey(), pl)
+	return
+}
+
+// forget drops the snapshot of a deleted TrackedModel, so saving it again puts it
+func forget(m Model) {
+	if tm, ok := m.(TrackedModel); ok {
+		tm.SetSnapshot(nil, nil)
+		tm.SetChanged(nil)
+	}
+}
+
+// trackChanges sets the changed properties of a TrackedModel
+// and reports whether m has to be put (always true for models that aren't tracked)
+func trackChanges(m Model) (changed bool, myerr error) {
+	tm, ok := m.(TrackedModel)
+	if !ok {
+		changed = true
+		return
+	}
+
+	pl, myerr := modelProperties(m)
+	if myerr != nil {
+		return
+	}
+
+	names := diffProperties(tm.Snapshot(), pl)
+	tm.SetChanged(names)
+
+	k := m.GetKey()
+	changed = tm.Snapshot() == nil || k == nil || k.Incomplete() || !k.Equal(tm.SnapshotKey()) || len(names) > 0
+	return
+}
+
+// diffProperties returns the sorted names of the properties whose values differ between a and b
+func diffProperties(a, b []datastore.Property) (names []string) {
+	values := func(pl []datastore.Property) map[string][]interface{} {
+		vs := make(map[string][]interface{})
+		for _, p := range pl {
+			vs[p.Name] = append(vs[p.Name], p.Value)
+		}
+		return vs
+	}
+
+	av, bv := values(a), values(b)
+	for n, v := range av {
+		if !reflect.DeepEqual(v, bv[n]) {
+			names = append(names, n)
+		}
+	}
+	for n := range bv {
+		if _, ok := av[n]; !ok {
+			names = append(names, n)
+		}
+	}
+
+	sort.Strings(names)
+	return
+}
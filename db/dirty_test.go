@@ -0,0 +1,143 @@
+package db
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"google.golang.org/appengine/datastore"
+)
+
+type Memo struct {
+	base
+	Tracked `datastore:"-"`
+	Title   string
+	Body    string `datastore:",noindex"`
+
+	preSaved  []string `datastore:"-"`
+	postSaves int      `datastore:"-"`
+}
+
+func (m *Memo) EntityType() string {
+	return "Memo"
+}
+
+func (m *Memo) PreSave(c context.Context) error {
+	m.preSaved = m.Changed()
+	if m.GetKey() == nil {
+		m.SetIsNew(true)
+		m.SetKey(datastore.NewIncompleteKey(c, m.EntityType(), nil))
+	}
+	return nil
+}
+
+func (m *Memo) PostSave(c context.Context) error {
+	m.postSaves++
+	return nil
+}
+
+func TestDiffProperties(t *testing.T) {
+	a := []datastore.Property{{Name: "A", Value: "a"}, {Name: "B", Value: int64(1)}, {Name: "C", Value: "c", Multiple: true}}
+	b := []datastore.Property{{Name: "A", Value: "a"}, {Name: "B", Value: int64(2)}, {Name: "C", Value: "c", Multiple: true}, {Name: "C", Value: "d", Multiple: true}, {Name: "D", Value: true}}
+
+	if got := diffProperties(a, b); !reflect.DeepEqual(got, []string{"B", "C", "D"}) {
+		t.Fatalf("diffProperties returned the wrong names. Wanted: [B C D]; Got: %v", got)
+	}
+
+	if got := diffProperties(a, a); got != nil {
+		t.Fatalf("diffProperties found changes in equal properties. Got: %v", got)
+	}
+}
+
+func TestDirtyTracking(t *testing.T) {
+	defer ResetDB()
+	ctx := GetCtx()
+
+	defer UncaptureChanges("Memo")
+
+	var saves int
+	sub := ListenSaved("Memo", func(ctx context.Context, c *Change) error {
+		saves++
+		return nil
+	}, 0)
+	defer sub.Unlisten()
+
+	m := &Memo{Title: "title", Body: "body"}
+	if err := Save(ctx, m); err != nil {
+		t.Fatalf("Save threw an error. Error: %v", err)
+	}
+	if !reflect.DeepEqual(m.preSaved, []string{"Body", "Title"}) || saves != 1 {
+		t.Fatalf("Save did not treat a new model as changed. Got: %v, %d saves", m.preSaved, saves)
+	}
+
+	var got Memo
+	if _, err := Load(ctx, m.GetKey(), &got); err != nil {
+		t.Fatalf("Load threw an error. Error: %v", err)
+	}
+	if got.Snapshot() == nil {
+		t.Fatal("Load did not snapshot the model")
+	}
+
+	// nothing changed, so nothing gets put
+	if err := Save(ctx, &got); err != nil {
+		t.Fatalf("Save threw an error. Error: %v", err)
+	}
+	if got.postSaves != 0 || saves != 1 {
+		t.Fatalf("Save put an unchanged model. Got: %d post saves, %d saves", got.postSaves, saves)
+	}
+
+	got.Body = "changed"
+	if err := SaveMulti(ctx, []Model{&got, m}); err != nil {
+		t.Fatalf("SaveMulti threw an error. Error: %v", err)
+	}
+	if !reflect.DeepEqual(got.preSaved, []string{"Body"}) || !got.IsChanged("Body") || got.IsChanged("Title") {
+		t.Fatalf("SaveMulti set the wrong changes. Got: %v", got.Changed())
+	}
+	if got.postSaves != 1 || m.postSaves != 1 || saves != 2 {
+		t.Fatalf("SaveMulti put the wrong models. Got: %d and %d post saves, %d saves", got.postSaves, m.postSaves, saves)
+	}
+
+	// the saved state is the new snapshot
+	if err := Save(ctx, &got); err != nil || got.postSaves != 1 {
+		t.Fatalf("Save put a model that did not change since it was saved. Error: %v", err)
+	}
+}
+
+func TestDirtyKeyAndDelete(t *testing.T) {
+	defer ResetDB()
+	ctx := GetCtx()
+
+	m := &Memo{Title: "title", Body: "body"}
+	if err := Save(ctx, m); err != nil {
+		t.Fatalf("Save threw an error. Error: %v", err)
+	}
+
+	var got Memo
+	if _, err := Load(ctx, m.GetKey(), &got); err != nil {
+		t.Fatalf("Load threw an error. Error: %v", err)
+	}
+
+	// copying a loaded model to a new key puts it, even without changed properties
+	copied := datastore.NewKey(ctx, got.EntityType(), "copy", 0, nil)
+	got.SetKey(copied)
+	if err := Save(ctx, &got); err != nil || got.postSaves != 1 {
+		t.Fatalf("Save did not put a model with a new key. Error: %v", err)
+	}
+
+	var c Memo
+	if _, err := Load(ctx, copied, &c); err != nil || c.Title != "title" {
+		t.Fatalf("Save did not write the copy. Error: %v", err)
+	}
+
+	// saving a deleted model puts it back
+	if err := Delete(ctx, &c); err != nil {
+		t.Fatalf("Delete threw an error. Error: %v", err)
+	}
+	if err := Save(ctx, &c); err != nil || c.postSaves != 1 {
+		t.Fatalf("Save did not put a deleted model. Error: %v", err)
+	}
+
+	if _, err := Load(ctx, copied, &Memo{}); err != nil {
+		t.Fatalf("Save did not write the deleted model back. Error: %v", err)
+	}
+}
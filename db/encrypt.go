@@ -0,0 +1,250 @@
+package db
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"sync"
+
+	"golang.org/x/crypto/hkdf"
+	"google.golang.org/appengine/datastore"
+
+	"github.com/benjamw/golibs/crypto"
+)
+
+// tagEncrypted marks a string or []byte field to be stored encrypted: `db:",encrypted"`
+const tagEncrypted = "encrypted"
+
+// encryptedVersion is the first byte of every encrypted value
+const encryptedVersion = 1
+
+// the second byte of every encrypted value, the type of the original value
+const (
+	encryptedString = 's'
+	encryptedBytes  = 'b'
+)
+
+// encryptedInfo is the HKDF info the encryption and signing keys are derived with
+const encryptedInfo = "golibs/db encrypted property"
+
+// KeyProvider supplies the keys used for encrypted properties
+//
+// Every encrypted value is stored along with the ID of the key that encrypted it,
+// so keys can be rotated by changing the current key while still providing the old ones.
+type KeyProvider interface {
+	// CurrentKey returns the ID and key used to encrypt values
+	CurrentKey(ctx context.Context) (id string, key []byte, err error)
+
+	// Key returns the key with the given ID, used to decrypt values
+	Key(ctx context.Context, id string) ([]byte, error)
+}
+
+var (
+	keyProviderMu sync.RWMutex
+	keyProvider   KeyProvider
+)
+
+// SetKeyProvider sets the KeyProvider used for encrypted properties
+func SetKeyProvider(kp KeyProvider) {
+	keyProviderMu.Lock()
+	defer keyProviderMu.Unlock()
+
+	keyProvider = kp
+}
+
+// getKeyProvider returns the KeyProvider set with SetKeyProvider
+func getKeyProvider() KeyProvider {
+	keyProviderMu.RLock()
+	defer keyProviderMu.RUnlock()
+
+	return keyProvider
+}
+
+// StaticKeys is a KeyProvider that holds a fixed set of AES keys (16, 24 or 32 bytes long)
+type StaticKeys struct {
+	Current string            // the ID of the key used to encrypt values
+	Keys    map[string][]byte // all known keys by ID
+}
+
+// CurrentKey satisfies the KeyProvider interface
+func (s *StaticKeys) CurrentKey(ctx context.Context) (string, []byte, error) {
+	key, err := s.Key(ctx, s.Current)
+	return s.Current, key, err
+}
+
+// Key satisfies the KeyProvider interface
+func (s *StaticKeys) Key(ctx context.Context, id string) ([]byte, error) {
+	key, ok := s.Keys[id]
+	if !ok {
+		return nil, errors.New("unknown key ID " + id)
+	}
+
+	return key, nil
+}
+
+// encryptProperties encrypts the properties of m that are tagged as encrypted
+func encryptProperties(ctx context.Context, m interface{}, pl []datastore.Property) ([]datastore.Property, error) {
+	names := taggedFields(m, tagEncrypted)
+	if len(names) == 0 {
+		return pl, nil
+	}
+
+	var id string
+	var key *valueKeys
+	out := make([]datastore.Property, len(pl))
+	for i, p := range pl {
+		out[i] = p
+		if !hasProperty(names, p.Name) || p.Value == nil {
+			continue
+		}
+
+		if key == nil {
+			kp := getKeyProvider()
+			if kp == nil {
+				return nil, &EncryptionError{Property: p.Name, Err: errors.New("no key provider set")}
+			}
+
+			cid, k, err := kp.CurrentKey(ctx)
+			if err != nil {
+				return nil, &EncryptionError{Property: p.Name, Err: err}
+			}
+			if len(cid) > 255 {
+				return nil, &EncryptionError{Property: p.Name, Err: errors.New("key ID longer than 255 bytes")}
+			}
+			if key, err = deriveKeys(k); err != nil {
+				return nil, &EncryptionError{Property: p.Name, Err: err}
+			}
+			id = cid
+		}
+
+		var typ byte
+		var plain []byte
+		switch v := p.Value.(type) {
+		case string:
+			typ, plain = encryptedString, []byte(v)
+		case []byte:
+			typ, plain = encryptedBytes, v
+		default:
+			return nil, &EncryptionError{Property: p.Name, Err: errors.New("only string and []byte values can be encrypted")}
+		}
+
+		ct, err := crypto.Encrypt(plain, key.enc)
+		if err != nil {
+			return nil, &EncryptionError{Property: p.Name, Err: err}
+		}
+
+		// the header and the cipher text are signed together, so neither can be changed
+		v := make([]byte, 0, 3+len(id)+sha256.Size+len(ct))
+		v = append(v, encryptedVersion, typ, byte(len(id)))
+		v = append(v, id...)
+		v = append(v, key.sign(v, ct)...)
+		v = append(v, ct...)
+
+		out[i].Value = v
+		out[i].NoIndex = true
+	}
+
+	return out, nil
+}
+
+// decryptProperties decrypts the properties of m that are tagged as encrypted
+func decryptProperties(ctx context.Context, m interface{}, pl []datastore.Property) ([]datastore.Property, error) {
+	names := taggedFields(m, tagEncrypted)
+	if len(names) == 0 {
+		return pl, nil
+	}
+
+	keys := make(map[string]*valueKeys)
+	out := make([]datastore.Property, len(pl))
+	for i, p := range pl {
+		out[i] = p
+		if !hasProperty(names, p.Name) || p.Value == nil {
+			continue
+		}
+
+		v, ok := p.Value.([]byte)
+		if !ok || len(v) < 3 || v[0] != encryptedVersion || len(v) < 3+int(v[2])+sha256.Size {
+			// values saved before the field was encrypted are loaded as they are
+			continue
+		}
+
+		typ := v[1]
+		header := v[:3+int(v[2])]
+		id := string(header[3:])
+		sig := v[len(header) : len(header)+sha256.Size]
+		ct := v[len(header)+sha256.Size:]
+
+		key, found := keys[id]
+		if !found {
+			kp := getKeyProvider()
+			if kp == nil {
+				return nil, &EncryptionError{Property: p.Name, Err: errors.New("no key provider set")}
+			}
+
+			k, err := kp.Key(ctx, id)
+			if err != nil {
+				return nil, &EncryptionError{Property: p.Name, Err: err}
+			}
+			if key, err = deriveKeys(k); err != nil {
+				return nil, &EncryptionError{Property: p.Name, Err: err}
+			}
+			keys[id] = key
+		}
+
+		if !hmac.Equal(sig, key.sign(header, ct)) {
+			return nil, &EncryptionError{Property: p.Name, Err: errors.New("signature mismatch")}
+		}
+
+		plain, err := crypto.Decrypt(ct, key.enc)
+		if err != nil {
+			return nil, &EncryptionError{Property: p.Name, Err: err}
+		}
+
+		switch typ {
+		case encryptedString:
+			out[i].Value = string(plain)
+		case encryptedBytes:
+			out[i].Value = plain
+		default:
+			return nil, &EncryptionError{Property: p.Name, Err: errors.New("unknown encrypted value type")}
+		}
+	}
+
+	return out, nil
+}
+
+// valueKeys are the keys derived from a key of the KeyProvider,
+// so encrypting and signing values never use the same key
+type valueKeys struct {
+	enc []byte // the AES key, as long as the provided key
+	mac []byte // the HMAC-SHA256 key
+}
+
+// deriveKeys derives the encryption and signing keys from the provided key with HKDF
+func deriveKeys(key []byte) (*valueKeys, error) {
+	r := hkdf.New(sha256.New, key, nil, []byte(encryptedInfo))
+
+	k := &valueKeys{
+		enc: make([]byte, len(key)),
+		mac: make([]byte, sha256.Size),
+	}
+	if _, err := io.ReadFull(r, k.enc); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(r, k.mac); err != nil {
+		return nil, err
+	}
+
+	return k, nil
+}
+
+// sign returns the HMAC of the header and the cipher text of a value
+func (k *valueKeys) sign(header []byte, ct []byte) []byte {
+	mac := hmac.New(sha256.New, k.mac)
+	mac.Write(header)
+	mac.Write(ct)
+
+	return mac.Sum(nil)
+}
@@ -0,0 +1,184 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"google.golang.org/appengine/datastore"
+)
+
+var testKeys = &StaticKeys{
+	Current: "one",
+	Keys: map[string][]byte{
+		"one": []byte("0123456789abcdef0123456789abcdef"),
+		"two": []byte("fedcba9876543210fedcba9876543210"),
+	},
+}
+
+func TestEncryptedSaveLoad(t *testing.T) {
+	defer ResetDB()
+	defer SetKeyProvider(nil)
+	ctx := GetCtx()
+
+	SetKeyProvider(testKeys)
+
+	s := Secret{
+		Name: "visible",
+		SSN:  "123-45-6789",
+		Data: []byte("hidden bytes"),
+	}
+	if err := Save(ctx, &s); err != nil {
+		t.Fatalf("Save threw an error for an encrypted model. Error: %v", err)
+	}
+
+	// the stored values should not be readable
+	var pl datastore.PropertyList
+	if err := datastore.Get(ctx, s.GetKey(), &pl); err != nil {
+		t.Fatalf("Could not get the raw properties. Error: %v", err)
+	}
+	for _, p := range pl {
+		switch p.Name {
+		case "Name":
+			if p.Value != "visible" {
+				t.Fatalf("Save changed a property that is not encrypted. Got: %v", p.Value)
+			}
+		case "SSN", "Data":
+			v, ok := p.Value.([]byte)
+			if !ok {
+				t.Fatalf("Save did not store encrypted property %s as bytes. Got: %T", p.Name, p.Value)
+			}
+			if bytes.Contains(v, []byte("123-45-6789")) || bytes.Contains(v, []byte("hidden bytes")) {
+				t.Fatalf("Save stored encrypted property %s in plain text", p.Name)
+			}
+		}
+	}
+
+	var m Secret
+	if _, err := Load(ctx, s.GetKey(), &m); err != nil {
+		t.Fatalf("Load threw an error for an encrypted model. Error: %v", err)
+	}
+	if m.SSN != s.SSN || !bytes.Equal(m.Data, s.Data) || m.Name != s.Name {
+		t.Fatalf("Load did not decrypt the model. Got: %+v", m)
+	}
+	if !m.loaded {
+		t.Fatal("Load did not call PostLoad on the decrypted model")
+	}
+}
+
+func TestEncryptedKeyRotation(t *testing.T) {
+	defer ResetDB()
+	defer SetKeyProvider(nil)
+	ctx := GetCtx()
+
+	keys := &StaticKeys{
+		Current: "one",
+		Keys: map[string][]byte{
+			"one": testKeys.Keys["one"],
+			"two": testKeys.Keys["two"],
+		},
+	}
+	SetKeyProvider(keys)
+
+	s := Secret{SSN: "rotate me"}
+	if err := Save(ctx, &s); err != nil {
+		t.Fatalf("Save threw an error. Error: %v", err)
+	}
+
+	keys.Current = "two"
+
+	models := []Model{new(Secret)}
+	if _, err := LoadMulti(ctx, []*datastore.Key{s.GetKey()}, models); err != nil {
+		t.Fatalf("LoadMulti could not decrypt a value encrypted with an older key. Error: %v", err)
+	}
+	if models[0].(*Secret).SSN != "rotate me" {
+		t.Fatalf("LoadMulti did not decrypt the model. Got: %s", models[0].(*Secret).SSN)
+	}
+
+	// re-saving uses the new key, so the old one can be dropped
+	if err := SaveMulti(ctx, models); err != nil {
+		t.Fatalf("SaveMulti threw an error. Error: %v", err)
+	}
+
+	// perform a Get to force the save to be applied so it's available in queries
+	if err := datastore.Get(ctx, s.GetKey(), &datastore.PropertyList{}); err != nil {
+		t.Fatalf("Could not get the re-saved Secret. Error: %v", err)
+	}
+
+	delete(keys.Keys, "one")
+
+	var secrets []*Secret
+	if _, err := Query(ctx, datastore.NewQuery(new(Secret).EntityType()), &secrets); err != nil {
+		t.Fatalf("Query could not decrypt a re-saved value. Error: %v", err)
+	}
+	if len(secrets) != 1 || secrets[0].SSN != "rotate me" {
+		t.Fatalf("Query did not decrypt the model. Got: %+v", secrets)
+	}
+}
+
+func TestEncryptedNoKeyProvider(t *testing.T) {
+	defer ResetDB()
+	ctx := GetCtx()
+
+	SetKeyProvider(nil)
+
+	err := Save(ctx, &Secret{SSN: "nope"})
+	if _, ok := err.(*EncryptionError); !ok {
+		t.Fatalf("Save did not throw an EncryptionError without a key provider. Got: %T: %v", err, err)
+	}
+}
+
+func TestEncryptedTamper(t *testing.T) {
+	ctx := GetCtx()
+
+	defer SetKeyProvider(nil)
+	SetKeyProvider(testKeys)
+
+	m := &Secret{SSN: "123-45-6789"}
+	pl, err := encryptProperties(ctx, m, []datastore.Property{{Name: "SSN", Value: m.SSN}})
+	if err != nil {
+		t.Fatalf("encryptProperties threw an error. Error: %v", err)
+	}
+
+	if _, err = decryptProperties(ctx, m, pl); err != nil {
+		t.Fatalf("decryptProperties threw an error. Error: %v", err)
+	}
+
+	// every byte of the header is signed: the version is left alone, as values
+	// of another version are loaded as they are
+	v := pl[0].Value.([]byte)
+	for i := 1; i < 3+int(v[2]); i++ {
+		tampered := append([]byte(nil), v...)
+		tampered[i] ^= 1
+
+		_, err = decryptProperties(ctx, m, []datastore.Property{{Name: "SSN", Value: tampered}})
+		if _, ok := err.(*EncryptionError); !ok {
+			t.Fatalf("decryptProperties did not detect a changed header byte %d. Got: %T: %v", i, err, err)
+		}
+	}
+}
+
+type Secret struct {
+	base
+	Name   string
+	SSN    string `datastore:",noindex" db:",encrypted"`
+	Data   []byte `db:",encrypted"`
+	loaded bool
+}
+
+func (m *Secret) EntityType() string {
+	return "Secret"
+}
+
+func (m *Secret) PreSave(c context.Context) error {
+	if m.GetKey() == nil {
+		m.SetIsNew(true)
+		m.SetKey(datastore.NewIncompleteKey(c, m.EntityType(), nil))
+	}
+	return nil
+}
+
+func (m *Secret) PostLoad(c context.Context) error {
+	m.loaded = true
+	return m.base.PostLoad(c)
+}
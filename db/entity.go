@@ -0,0 +1,81 @@
+package db
+
+import (
+	"context"
+
+	"google.golang.org/appengine/datastore"
+)
+
+// Entity is a generic Model that holds the raw properties of an entity of any kind
+// It can be used anywhere a Model is expected when the concrete model type is unknown
+type Entity struct {
+	Kind       string
+	Key        *datastore.Key
+	Properties datastore.PropertyList
+}
+
+// NewEntity returns an empty Entity of the given kind
+func NewEntity(kind string) *Entity {
+	return &Entity{Kind: kind}
+}
+
+func (e *Entity) EntityType() string {
+	if e.Kind == "" && e.Key != nil {
+		return e.Key.Kind()
+	}
+
+	return e.Kind
+}
+
+func (e *Entity) GetKey() *datastore.Key {
+	return e.Key
+}
+
+func (e *Entity) SetKey(k *datastore.Key) error {
+	e.Key = k
+	if k != nil {
+		e.Kind = k.Kind()
+	}
+
+	return nil
+}
+
+func (e *Entity) PreSave(ctx context.Context) error {
+	if e.Key == nil {
+		e.Key = datastore.NewIncompleteKey(ctx, e.EntityType(), nil)
+	}
+
+	return nil
+}
+
+func (e *Entity) PostSave(ctx context.Context) error {
+	return nil
+}
+
+func (e *Entity) PostLoad(ctx context.Context) error {
+	if e.Key == nil {
+		return &MissingKeyError{}
+	}
+
+	return nil
+}
+
+func (e *Entity) PreDelete(ctx context.Context) error {
+	return nil
+}
+
+func (e *Entity) Transform(ctx context.Context, pl datastore.PropertyList) error {
+	e.Properties = pl
+	return nil
+}
+
+// Load satisfies the datastore.PropertyLoadSaver interface
+func (e *Entity) Load(p []datastore.Property) error {
+	e.Properties = append(e.Properties[:0], p...)
+	return nil
+}
+
+// Save satisfies the datastore.PropertyLoadSaver interface
+func (e *Entity) Save() ([]datastore.Property, error) {
+	return e.Properties, nil
+}
@@ -1,10 +1,17 @@
 package db
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
 )
 
+// ErrNotFound matches (with errors.Is) every error db returns for a missing entity
+var ErrNotFound = errors.New("not found")
+
 // UnfoundObjectError gets thrown when an object is not found in the database
 type UnfoundObjectError struct {
 	EntityType string // model.EntityType() response ("Vendor", "Asset", etc)
@@ -21,6 +28,16 @@ func (e *UnfoundObjectError) Code() int {
 	return http.StatusNotFound
 }
 
+// Unwrap returns the original error
+func (e *UnfoundObjectError) Unwrap() error {
+	return e.Err
+}
+
+// Is makes errors.Is(err, ErrNotFound) true
+func (e *UnfoundObjectError) Is(target error) bool {
+	return target == ErrNotFound
+}
+
 // MissingKeyError gets thrown when the datastore key has not been attached to an object before calling PostLoad
 type MissingKeyError struct {
 }
@@ -65,3 +82,159 @@ func (e *MissingRequiredError) Error() string {
 }
 
 // No Code() method for MissingRequiredError because it should not propagate to the user
+
+// ImportError gets thrown when a line of an import can not be decoded or saved
+type ImportError struct {
+	Line int   // the line of the import that failed
+	Err  error // the original error
+}
+
+func (e *ImportError) Error() string {
+	return fmt.Sprintf("import failed on line %d: %v", e.Line, e.Err)
+}
+
+// Unwrap returns the original error
+func (e *ImportError) Unwrap() error {
+	return e.Err
+}
+
+// No Code() method for ImportError because it should not propagate to the user
+
+// RetryError gets thrown when a datastore call still failed after being retried
+type RetryError struct {
+	Attempts []error // the error of each attempt, in order
+}
+
+func (e *RetryError) Error() string {
+	return fmt.Sprintf("failed after %d attempts: %v", len(e.Attempts), e.Attempts[len(e.Attempts)-1])
+}
+
+// Unwrap returns the error of each attempt so errors.Is and errors.As can look at all of them
+func (e *RetryError) Unwrap() []error {
+	return e.Attempts
+}
+
+// No Code() method for RetryError because it should not propagate to the user
+
+// MultiError gets thrown when some of the entities of a LoadMulti can not be loaded
+// (wrapped in an UnfoundObjectError if some of them are missing)
+type MultiError struct {
+	Errs appengine.MultiError // the error of every entity, in the order of the keys, nil for the ones that loaded
+}
+
+func (e *MultiError) Error() string {
+	return e.Errs.Error()
+}
+
+// Unwrap returns the errors of the entities that failed so errors.Is and errors.As can look at all of them
+func (e *MultiError) Unwrap() []error {
+	var errs []error
+	for _, err := range e.Errs {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errs
+}
+
+// As makes errors.As(err, &me) with an appengine.MultiError get the error of every entity
+func (e *MultiError) As(target interface{}) bool {
+	me, ok := target.(*appengine.MultiError)
+	if ok {
+		*me = e.Errs
+	}
+
+	return ok
+}
+
+// No Code() method for MultiError because it should not propagate to the user
+
+// EncryptionError gets thrown when an encrypted property can not be encrypted or decrypted
+type EncryptionError struct {
+	Property string // the name of the property
+	Err      error  // the original error
+}
+
+func (e *EncryptionError) Error() string {
+	return fmt.Sprintf("cannot encrypt or decrypt property %s: %v", e.Property, e.Err)
+}
+
+// Unwrap returns the original error
+func (e *EncryptionError) Unwrap() error {
+	return e.Err
+}
+
+// No Code() method for EncryptionError because it should not propagate to the user
+
+// UnregisteredKindError gets thrown when db needs to create a Model of a kind that was never registered with RegisterKind
+type UnregisteredKindError struct {
+	Kind string // the kind that is not registered
+}
+
+func (e *UnregisteredKindError) Error() string {
+	return fmt.Sprintf("kind %s is not registered", e.Kind)
+}
+
+// No Code() method for UnregisteredKindError because it should not propagate to the user
+
+// PartialSaveError gets thrown when saving a model that was loaded with LoadPartial
+type PartialSaveError struct {
+	EntityType string // model.EntityType() response ("Vendor", "Asset", etc)
+}
+
+func (e *PartialSaveError) Error() string {
+	return fmt.Sprintf("cannot save a partially loaded %s", e.EntityType)
+}
+
+// No Code() method for PartialSaveError because it should not propagate to the user
+
+// BatchKindError gets thrown when a Batch is run with the name of a checkpoint of another kind
+type BatchKindError struct {
+	Name           string // the name of the batch
+	Kind           string // the kind of the batch
+	CheckpointKind string // the kind of the stored checkpoint
+}
+
+func (e *BatchKindError) Error() string {
+	return fmt.Sprintf("batch %s has a checkpoint for kind %s, not %s", e.Name, e.CheckpointKind, e.Kind)
+}
+
+// No Code() method for BatchKindError because it should not propagate to the user
+
+// IsFieldMismatch reports whether err only comes from loading entities with properties their struct doesn't have,
+// either as a datastore.ErrFieldMismatch or as an appengine.MultiError of nothing else
+func IsFieldMismatch(err error) bool {
+	var me appengine.MultiError
+	if errors.As(err, &me) {
+		found := false
+		for _, e := range me {
+			if e == nil {
+				continue
+			}
+			if !IsFieldMismatch(e) {
+				return false
+			}
+			found = true
+		}
+		return found
+	}
+
+	var fm *datastore.ErrFieldMismatch
+	return errors.As(err, &fm)
+}
+
+// isNotFound reports whether err is (or, as an appengine.MultiError, holds) a datastore.ErrNoSuchEntity
+func isNotFound(err error) bool {
+	var me appengine.MultiError
+	if errors.As(err, &me) {
+		for _, e := range me {
+			if isNotFound(e) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return errors.Is(err, datastore.ErrNoSuchEntity)
+}
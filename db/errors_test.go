@@ -0,0 +1,87 @@
+package db
+
+import (
+	"errors"
+	"testing"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+func TestIsFieldMismatch(t *testing.T) {
+	fm := &datastore.ErrFieldMismatch{FieldName: "Gone", Reason: "no such struct field"}
+
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{fm, true},
+		{appengine.MultiError{nil, fm}, true},
+		{&RetryError{Attempts: []error{fm}}, true},
+		{appengine.MultiError{fm, datastore.ErrNoSuchEntity}, false},
+		{appengine.MultiError{nil, nil}, false},
+		{errors.New("datastore: cannot load field"), false},
+		{errors.New("something else entirely"), false},
+	}
+
+	for i, tt := range tests {
+		if got := IsFieldMismatch(tt.err); got != tt.want {
+			t.Fatalf("IsFieldMismatch returned the wrong result for error %d (%v). Wanted: %t; Got: %t", i, tt.err, tt.want, got)
+		}
+	}
+}
+
+func TestImportErrorUnwrap(t *testing.T) {
+	err := error(&ImportError{Line: 3, Err: datastore.ErrInvalidEntityType})
+
+	if !errors.Is(err, datastore.ErrInvalidEntityType) {
+		t.Fatalf("ImportError does not unwrap to the original error. Got: %v", err)
+	}
+}
+
+func TestEncryptionErrorUnwrap(t *testing.T) {
+	fm := &datastore.ErrFieldMismatch{FieldName: "Secret", Reason: "test"}
+	err := error(&EncryptionError{Property: "Secret", Err: fm})
+
+	var got *datastore.ErrFieldMismatch
+	if !errors.As(err, &got) || got != fm {
+		t.Fatalf("EncryptionError does not unwrap to the original error. Got: %v", err)
+	}
+}
+
+func TestNotFound(t *testing.T) {
+	defer ResetDB()
+	ctx := GetCtx()
+
+	f := createFoo(ctx, t)
+	missing := datastore.NewKey(ctx, new(Foo).EntityType(), "", 100, nil)
+
+	var m Foo
+	_, err := Load(ctx, missing, &m)
+	if !errors.Is(err, ErrNotFound) || !errors.Is(err, datastore.ErrNoSuchEntity) {
+		t.Fatalf("Load did not throw ErrNotFound. Got: %v", err)
+	}
+
+	var uoe *UnfoundObjectError
+	if !errors.As(err, &uoe) || uoe.EntityType != "Foo" {
+		t.Fatalf("Load did not throw an UnfoundObjectError. Got: %T: %v", err, err)
+	}
+
+	_, err = LoadMulti(ctx, []*datastore.Key{f.GetKey(), missing}, []Model{new(Foo), new(Foo)})
+	if !errors.Is(err, ErrNotFound) || !errors.As(err, &uoe) || uoe.Value != missing.Encode() {
+		t.Fatalf("LoadMulti did not throw ErrNotFound for the missing key. Got: %v", err)
+	}
+	if !errors.Is(err, datastore.ErrNoSuchEntity) {
+		t.Fatalf("LoadMulti did not throw datastore.ErrNoSuchEntity for the missing key. Got: %v", err)
+	}
+
+	var me appengine.MultiError
+	if !errors.As(err, &me) || len(me) != 2 || me[0] != nil || me[1] != datastore.ErrNoSuchEntity {
+		t.Fatalf("LoadMulti did not keep the error of every entity. Got: %v", me)
+	}
+
+	_, err = NewRepo[*Foo]().Query().Filter("__key__ =", missing).First(ctx)
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("First did not throw ErrNotFound. Got: %v", err)
+	}
+}
@@ -0,0 +1,447 @@
+package db
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+// DefaultImportBatchSize is the number of entities saved per SaveMulti call during Import
+const DefaultImportBatchSize = 500
+
+// ExportedKey is the JSON representation of a datastore key
+// The app ID is left out on purpose so keys can be imported into a different project
+type ExportedKey struct {
+	Namespace string            `json:"namespace,omitempty"`
+	Path      []ExportedKeyPart `json:"path"`
+}
+
+// ExportedKeyPart is a single kind and ID (or name) pair in a key path, root first
+type ExportedKeyPart struct {
+	Kind string `json:"kind"`
+	ID   int64  `json:"id,omitempty"`
+	Name string `json:"name,omitempty"`
+}
+
+// ExportedProperty is the JSON representation of a datastore property with its value type
+type ExportedProperty struct {
+	Name     string          `json:"name"`
+	Type     string          `json:"type"`
+	Value    json.RawMessage `json:"value,omitempty"`
+	NoIndex  bool            `json:"noindex,omitempty"`
+	Multiple bool            `json:"multiple,omitempty"`
+}
+
+// ExportedEntity is a single line of an export
+type ExportedEntity struct {
+	Key        *ExportedKey       `json:"key"`
+	Properties []ExportedProperty `json:"properties"`
+}
+
+type exportedGeoPoint struct {
+	Lat float64 `json:"lat"`
+	Lng float64 `json:"lng"`
+}
+
+// ImportOptions changes how Import saves the entities it reads
+type ImportOptions struct {
+	// Remap, if set, is called for every entity key and key valued property
+	// and the returned key is used in its place
+	Remap func(context.Context, *datastore.Key) (*datastore.Key, error)
+
+	// DryRun decodes and remaps every entity without saving anything
+	DryRun bool
+
+	// BatchSize is the number of entities saved per SaveMulti call
+	BatchSize int
+}
+
+// Export streams every entity of the given kind to w as JSON Lines
+// The namespace is taken from the context (see appengine.Namespace)
+func Export(ctx context.Context, w io.Writer, kind string) (n int, myerr error) {
+	enc := json.NewEncoder(w)
+
+	t := datastore.NewQuery(kind).Run(ctx)
+	for {
+		var pl datastore.PropertyList
+		k, err := t.Next(&pl)
+		if err == datastore.Done {
+			break
+		}
+		if err != nil {
+			myerr = err
+			return
+		}
+
+		ee, err := ExportEntity(k, pl)
+		if err != nil {
+			myerr = err
+			return
+		}
+
+		if myerr = enc.Encode(ee); myerr != nil {
+			return
+		}
+
+		n++
+	}
+
+	return
+}
+
+// ExportNamespace streams every entity of every kind in the context's namespace to w as JSON Lines
+// Datastore statistics and metadata kinds (those starting with "__") are skipped
+func ExportNamespace(ctx context.Context, w io.Writer) (n int, myerr error) {
+	kinds, myerr := datastore.Kinds(ctx)
+	if myerr != nil {
+		return
+	}
+
+	for _, kind := range kinds {
+		if strings.HasPrefix(kind, "__") {
+			continue
+		}
+
+		var c int
+		c, myerr = Export(ctx, w, kind)
+		n += c
+		if myerr != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// Import reads JSON Lines as written by Export from r and saves them through SaveMulti
+// It returns the number of entities saved (or that would have been saved on a dry run)
+func Import(ctx context.Context, r io.Reader, opts *ImportOptions) (n int, myerr error) {
+	if opts == nil {
+		opts = &ImportOptions{}
+	}
+
+	size := opts.BatchSize
+	if size <= 0 {
+		size = DefaultImportBatchSize
+	}
+
+	batch := make([]Model, 0, size)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+
+		if !opts.DryRun {
+			if err := SaveMulti(ctx, batch); err != nil {
+				return err
+			}
+		}
+
+		n += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+
+	line := 0
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64*1024), 2*1024*1024) // entities can be up to 1MB, plus JSON overhead
+	for s.Scan() {
+		line++
+		if len(strings.TrimSpace(s.Text())) == 0 {
+			continue
+		}
+
+		var ee ExportedEntity
+		if err := json.Unmarshal(s.Bytes(), &ee); err != nil {
+			myerr = &ImportError{Line: line, Err: err}
+			return
+		}
+
+		e, err := ImportEntity(ctx, &ee, opts.Remap)
+		if err != nil {
+			myerr = &ImportError{Line: line, Err: err}
+			return
+		}
+
+		batch = append(batch, e)
+		if len(batch) >= size {
+			if err = flush(); err != nil {
+				myerr = &ImportError{Line: line, Err: err}
+				return
+			}
+		}
+	}
+
+	if myerr = s.Err(); myerr != nil {
+		myerr = &ImportError{Line: line, Err: myerr}
+		return
+	}
+
+	if err := flush(); err != nil {
+		myerr = &ImportError{Line: line, Err: err}
+	}
+
+	return
+}
+
+// RemapNamespace returns an ImportOptions.Remap func that moves every key into the given namespace
+func RemapNamespace(namespace string) func(context.Context, *datastore.Key) (*datastore.Key, error) {
+	return func(ctx context.Context, k *datastore.Key) (*datastore.Key, error) {
+		ek := ExportKey(k)
+		ek.Namespace = namespace
+		return ImportKey(ctx, ek)
+	}
+}
+
+// ExportEntity converts a key and its properties into their JSON representation
+func ExportEntity(k *datastore.Key, pl []datastore.Property) (ee *ExportedEntity, myerr error) {
+	ee = &ExportedEntity{
+		Key:        ExportKey(k),
+		Properties: make([]ExportedProperty, len(pl)),
+	}
+
+	for i, p := range pl {
+		ep := ExportedProperty{
+			Name:     p.Name,
+			NoIndex:  p.NoIndex,
+			Multiple: p.Multiple,
+		}
+
+		var v interface{}
+		ep.Type, v, myerr = exportValue(p.Value)
+		if myerr != nil {
+			myerr = fmt.Errorf("property %s: %v", p.Name, myerr)
+			return
+		}
+
+		if v != nil {
+			if ep.Value, myerr = json.Marshal(v); myerr != nil {
+				return
+			}
+		}
+
+		ee.Properties[i] = ep
+	}
+
+	return
+}
+
+// ImportEntity converts the JSON representation of an entity back into an Entity,
+// passing every key through remap (if not nil)
+func ImportEntity(ctx context.Context, ee *ExportedEntity, remap func(context.Context, *datastore.Key) (*datastore.Key, error)) (e *Entity, myerr error) {
+	k, myerr := importKey(ctx, ee.Key, remap)
+	if myerr != nil {
+		return
+	}
+	if k == nil {
+		myerr = fmt.Errorf("entity is missing its key")
+		return
+	}
+
+	e = &Entity{
+		Kind:       k.Kind(),
+		Key:        k,
+		Properties: make(datastore.PropertyList, len(ee.Properties)),
+	}
+
+	for i, ep := range ee.Properties {
+		p := datastore.Property{
+			Name:     ep.Name,
+			NoIndex:  ep.NoIndex,
+			Multiple: ep.Multiple,
+		}
+
+		if p.Value, myerr = importValue(ctx, ep.Type, ep.Value, remap); myerr != nil {
+			myerr = fmt.Errorf("property %s: %v", ep.Name, myerr)
+			return
+		}
+
+		e.Properties[i] = p
+	}
+
+	return
+}
+
+// ExportKey converts a datastore key into its JSON representation
+func ExportKey(k *datastore.Key) *ExportedKey {
+	if k == nil {
+		return nil
+	}
+
+	ek := &ExportedKey{
+		Namespace: k.Namespace(),
+	}
+
+	for ; k != nil; k = k.Parent() {
+		ek.Path = append([]ExportedKeyPart{{
+			Kind: k.Kind(),
+			ID:   k.IntID(),
+			Name: k.StringID(),
+		}}, ek.Path...)
+	}
+
+	return ek
+}
+
+// ImportKey converts the JSON representation of a key back into a key for the current app
+func ImportKey(ctx context.Context, ek *ExportedKey) (k *datastore.Key, myerr error) {
+	if ek == nil {
+		return
+	}
+
+	if myerr = ctxNamespace(&ctx, ek.Namespace); myerr != nil {
+		return
+	}
+
+	for _, part := range ek.Path {
+		k = datastore.NewKey(ctx, part.Kind, part.Name, part.ID, k)
+	}
+
+	return
+}
+
+func importKey(ctx context.Context, ek *ExportedKey, remap func(context.Context, *datastore.Key) (*datastore.Key, error)) (k *datastore.Key, myerr error) {
+	if k, myerr = ImportKey(ctx, ek); myerr != nil || k == nil || remap == nil {
+		return
+	}
+
+	k, myerr = remap(ctx, k)
+	return
+}
+
+func ctxNamespace(ctx *context.Context, namespace string) (myerr error) {
+	if namespace == "" {
+		return
+	}
+
+	*ctx, myerr = appengine.Namespace(*ctx, namespace)
+	return
+}
+
+func exportValue(value interface{}) (typ string, v interface{}, myerr error) {
+	switch val := value.(type) {
+	case nil:
+		typ = "null"
+	case int64:
+		typ, v = "int", val
+	case bool:
+		typ, v = "bool", val
+	case string:
+		typ, v = "string", val
+	case float64:
+		typ, v = "float", val
+	case datastore.ByteString:
+		typ, v = "bytestring", base64.StdEncoding.EncodeToString(val)
+	case []byte:
+		typ, v = "blob", base64.StdEncoding.EncodeToString(val)
+	case *datastore.Key:
+		typ, v = "key", ExportKey(val)
+	case time.Time:
+		typ, v = "time", val.UTC().Format(time.RFC3339Nano)
+	case appengine.BlobKey:
+		typ, v = "blobkey", string(val)
+	case appengine.GeoPoint:
+		typ, v = "geo", exportedGeoPoint{Lat: val.Lat, Lng: val.Lng}
+	case *datastore.Entity:
+		typ = "entity"
+		v, myerr = ExportEntity(val.Key, val.Properties)
+	default:
+		myerr = fmt.Errorf("unsupported value type %T", value)
+	}
+
+	return
+}
+
+func importValue(ctx context.Context, typ string, raw json.RawMessage, remap func(context.Context, *datastore.Key) (*datastore.Key, error)) (v interface{}, myerr error) {
+	switch typ {
+	case "null":
+		return
+	case "int":
+		var i int64
+		myerr = json.Unmarshal(raw, &i)
+		v = i
+	case "bool":
+		var b bool
+		myerr = json.Unmarshal(raw, &b)
+		v = b
+	case "string":
+		var s string
+		myerr = json.Unmarshal(raw, &s)
+		v = s
+	case "float":
+		var f float64
+		myerr = json.Unmarshal(raw, &f)
+		v = f
+	case "bytestring", "blob":
+		var s string
+		if myerr = json.Unmarshal(raw, &s); myerr != nil {
+			return
+		}
+
+		var b []byte
+		if b, myerr = base64.StdEncoding.DecodeString(s); myerr != nil {
+			return
+		}
+
+		if typ == "bytestring" {
+			v = datastore.ByteString(b)
+		} else {
+			v = b
+		}
+	case "key":
+		var ek ExportedKey
+		if myerr = json.Unmarshal(raw, &ek); myerr != nil {
+			return
+		}
+		v, myerr = importKey(ctx, &ek, remap)
+	case "time":
+		var s string
+		if myerr = json.Unmarshal(raw, &s); myerr != nil {
+			return
+		}
+		v, myerr = time.Parse(time.RFC3339Nano, s)
+	case "blobkey":
+		var s string
+		myerr = json.Unmarshal(raw, &s)
+		v = appengine.BlobKey(s)
+	case "geo":
+		var g exportedGeoPoint
+		myerr = json.Unmarshal(raw, &g)
+		v = appengine.GeoPoint{Lat: g.Lat, Lng: g.Lng}
+	case "entity":
+		var ee ExportedEntity
+		if myerr = json.Unmarshal(raw, &ee); myerr != nil {
+			return
+		}
+
+		ent := &datastore.Entity{
+			Properties: make([]datastore.Property, len(ee.Properties)),
+		}
+		if ent.Key, myerr = importKey(ctx, ee.Key, remap); myerr != nil {
+			return
+		}
+		for i, ep := range ee.Properties {
+			ent.Properties[i] = datastore.Property{
+				Name:     ep.Name,
+				NoIndex:  ep.NoIndex,
+				Multiple: ep.Multiple,
+			}
+			if ent.Properties[i].Value, myerr = importValue(ctx, ep.Type, ep.Value, remap); myerr != nil {
+				return
+			}
+		}
+		v = ent
+	default:
+		myerr = fmt.Errorf("unsupported value type %q", typ)
+	}
+
+	return
+}
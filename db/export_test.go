@@ -0,0 +1,148 @@
+package db
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+func TestExportImport(t *testing.T) {
+	defer ResetDB()
+	ctx := GetCtx()
+
+	p := createFullFoo(ctx, t, "exported", 123)
+	createFoo(ctx, t)
+
+	var buf bytes.Buffer
+	n, err := Export(ctx, &buf, new(Foo).EntityType())
+	if err != nil {
+		t.Fatalf("Export threw an error. Error: %v", err)
+	}
+	if n != 2 {
+		t.Fatalf("Export exported the wrong number of entities. Wanted: 2; Got: %d", n)
+	}
+	if lines := strings.Count(buf.String(), "\n"); lines != 2 {
+		t.Fatalf("Export wrote the wrong number of lines. Wanted: 2; Got: %d", lines)
+	}
+
+	ResetDB()
+
+	// a dry run should not save anything
+	n, err = Import(ctx, bytes.NewReader(buf.Bytes()), &ImportOptions{DryRun: true})
+	if err != nil {
+		t.Fatalf("Import threw an error on a dry run. Error: %v", err)
+	}
+	if n != 2 {
+		t.Fatalf("Import dry run counted the wrong number of entities. Wanted: 2; Got: %d", n)
+	}
+
+	var m Foo
+	if found, _ := Load(ctx, p.GetKey(), &m); found {
+		t.Fatal("Import saved an entity on a dry run")
+	}
+
+	n, err = Import(ctx, bytes.NewReader(buf.Bytes()), &ImportOptions{BatchSize: 1})
+	if err != nil {
+		t.Fatalf("Import threw an error. Error: %v", err)
+	}
+	if n != 2 {
+		t.Fatalf("Import imported the wrong number of entities. Wanted: 2; Got: %d", n)
+	}
+
+	found, err := Load(ctx, p.GetKey(), &m)
+	if err != nil || !found {
+		t.Fatalf("Import did not save the entity under its original key. Error: %v", err)
+	}
+	if m.String != "exported" || m.Int != 123 {
+		t.Fatalf("Import did not save the correct properties. Got: %s, %d", m.String, m.Int)
+	}
+}
+
+func TestImportError(t *testing.T) {
+	defer ResetDB()
+	ctx := GetCtx()
+
+	_, err := Import(ctx, strings.NewReader("\n{not json}\n"), nil)
+	ie, ok := err.(*ImportError)
+	if !ok {
+		t.Fatalf("Import did not throw an ImportError on bad input. Got: %T: %v", err, err)
+	}
+	if ie.Line != 2 {
+		t.Fatalf("ImportError has the wrong line. Wanted: 2; Got: %d", ie.Line)
+	}
+}
+
+func TestExportEntityValues(t *testing.T) {
+	ctx := GetCtx()
+
+	parent := datastore.NewKey(ctx, "Parent", "p", 0, nil)
+	k := datastore.NewKey(ctx, "Child", "", 42, parent)
+	now := time.Now().UTC()
+
+	pl := datastore.PropertyList{
+		{Name: "Nil", Value: nil},
+		{Name: "Int", Value: int64(7)},
+		{Name: "Bool", Value: true},
+		{Name: "String", Value: "str", NoIndex: true},
+		{Name: "Float", Value: 1.5},
+		{Name: "ByteString", Value: datastore.ByteString("bs")},
+		{Name: "Blob", Value: []byte{0, 1, 2}},
+		{Name: "Key", Value: parent},
+		{Name: "Time", Value: now},
+		{Name: "Geo", Value: appengine.GeoPoint{Lat: 1, Lng: 2}},
+		{Name: "Multi", Value: "a", Multiple: true},
+		{Name: "Multi", Value: "b", Multiple: true},
+	}
+
+	ee, err := ExportEntity(k, pl)
+	if err != nil {
+		t.Fatalf("ExportEntity threw an error. Error: %v", err)
+	}
+
+	e, err := ImportEntity(ctx, ee, nil)
+	if err != nil {
+		t.Fatalf("ImportEntity threw an error. Error: %v", err)
+	}
+
+	if !e.Key.Equal(k) {
+		t.Fatalf("ImportEntity returned the wrong key. Wanted: %v; Got: %v", k, e.Key)
+	}
+
+	if len(e.Properties) != len(pl) {
+		t.Fatalf("ImportEntity returned the wrong number of properties. Wanted: %d; Got: %d", len(pl), len(e.Properties))
+	}
+
+	for i, p := range e.Properties {
+		want := pl[i]
+		if p.Name != want.Name || p.NoIndex != want.NoIndex || p.Multiple != want.Multiple {
+			t.Fatalf("ImportEntity returned the wrong property metadata. Wanted: %+v; Got: %+v", want, p)
+		}
+
+		switch v := p.Value.(type) {
+		case *datastore.Key:
+			if !v.Equal(want.Value.(*datastore.Key)) {
+				t.Fatalf("ImportEntity returned the wrong key value. Wanted: %v; Got: %v", want.Value, v)
+			}
+		case time.Time:
+			if !v.Equal(want.Value.(time.Time)) {
+				t.Fatalf("ImportEntity returned the wrong time value. Wanted: %v; Got: %v", want.Value, v)
+			}
+		case []byte:
+			if !bytes.Equal(v, want.Value.([]byte)) {
+				t.Fatalf("ImportEntity returned the wrong blob value. Wanted: %v; Got: %v", want.Value, v)
+			}
+		case datastore.ByteString:
+			if !bytes.Equal(v, want.Value.(datastore.ByteString)) {
+				t.Fatalf("ImportEntity returned the wrong bytestring value. Wanted: %v; Got: %v", want.Value, v)
+			}
+		default:
+			if v != want.Value {
+				t.Fatalf("ImportEntity returned the wrong %s value. Wanted: %v; Got: %v", p.Name, want.Value, v)
+			}
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package db
+
+import (
+	"context"
+	"math"
+	"sort"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+const (
+	// GeoIndexKind is the kind of the companion entities that hold the geohash cells of a model
+	GeoIndexKind = "GeoIndex"
+
+	// tagGeo marks a GeoPoint field as indexed for Near: `db:",geo"`
+	tagGeo = "geo"
+
+	// geoMaxPrecision is the length of the longest geohash cell that gets indexed (about 4.9m x 4.9m)
+	geoMaxPrecision = 9
+
+	// earthRadius is the mean radius of the Earth, in km
+	earthRadius = 6371.0
+
+	geoBase32 = "0123456789bcdefghjkmnpqrstuvwxyz"
+)
+
+// geoIndex is the companion entity that holds the geohash cells of a GeoPoint property of a model
+// Its parent is the key of the model it indexes, and its name is the name of the property
+type geoIndex struct {
+	Kind   string
+	Cells  []string             // the geohash of every point, at every precision
+	Points []appengine.GeoPoint `datastore:",noindex"`
+}
+
+type geoIndexer struct{}
+
+func init() {
+	indexers = append(indexers, geoIndexer{})
+}
+
+func (geoIndexer) tag() string {
+	return tagGeo
+}
+
+func (geoIndexer) kind() string {
+	return GeoIndexKind
+}
+
+func (geoIndexer) keys(ctx context.Context, k *datastore.Key, names []string) []*datastore.Key {
+	keys := make([]*datastore.Key, len(names))
+	for i, n := range names {
+		keys[i] = datastore.NewKey(ctx, GeoIndexKind, n, 0, k)
+	}
+
+	return keys
+}
+
+func (geoIndexer) entities(ctx context.Context, k *datastore.Key, names []string, pl []datastore.Property) (keys []*datastore.Key, vals []interface{}, myerr error) {
+	idxs := make(map[string]*geoIndex)
+	for _, p := range pl {
+		gp, ok := p.Value.(appengine.GeoPoint)
+		if !ok || !gp.Valid() || !hasProperty(names, p.Name) {
+			continue
+		}
+
+		idx := idxs[p.Name]
+		if idx == nil {
+			idx = &geoIndex{Kind: k.Kind()}
+			idxs[p.Name] = idx
+
+			keys = append(keys, datastore.NewKey(ctx, GeoIndexKind, p.Name, 0, k))
+			vals = append(vals, idx)
+		}
+
+		idx.Points = append(idx.Points, gp)
+
+		hash := geohash(gp, geoMaxPrecision)
+		for i := 1; i <= len(hash); i++ {
+			if !hasProperty(idx.Cells, hash[:i]) {
+				idx.Cells = append(idx.Cells, hash[:i])
+			}
+		}
+	}
+
+	return
+}
+
+// Near returns the models of the given kind with a geo property within radius km of the point, closest first
+// It skips the models that are missing
+//
+// The kind must be registered with RegisterKind.
+func Near(ctx context.Context, kind string, point appengine.GeoPoint, radius float64) (models []Model, myerr error) {
+	type result struct {
+		key      *datastore.Key
+		distance float64
+	}
+
+	var results []result
+	for _, cell := range geoCover(point, radius) {
+		q := datastore.NewQuery(GeoIndexKind).Filter("Kind =", kind)
+		if cell != "" {
+			q = q.Filter("Cells =", cell)
+		}
+
+		var indexes []geoIndex
+		var keys []*datastore.Key
+		if keys, myerr = Query(ctx, q, &indexes); myerr != nil {
+			return
+		}
+
+		for i, k := range keys {
+			d := math.Inf(1)
+			for _, p := range indexes[i].Points {
+				d = math.Min(d, haversine(point, p))
+			}
+
+			if d > radius {
+				continue
+			}
+
+			// a model can be found through several cells and properties
+			found := false
+			for j := range results {
+				if results[j].key.Equal(k.Parent()) {
+					results[j].distance = math.Min(results[j].distance, d)
+					found = true
+					break
+				}
+			}
+			if !found {
+				results = append(results, result{key: k.Parent(), distance: d})
+			}
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].distance < results[j].distance
+	})
+
+	modelKeys := make([]*datastore.Key, len(results))
+	for i, r := range results {
+		modelKeys[i] = r.key
+	}
+
+	models, myerr = loadExisting(ctx, kind, modelKeys)
+	return
+}
+
+// geoCover returns the geohash cells that cover the circle with the given radius (in km) around the point:
+// the cell of the point and its neighbours, at the longest precision whose cells are larger than the radius
+// It returns a single empty cell when even the largest cells are too small
+func geoCover(point appengine.GeoPoint, radius float64) (cells []string) {
+	precision := 0
+	for p := geoMaxPrecision; p > 0; p-- {
+		lat, lng := geoCellSize(p)
+		if lat*math.Pi/180*earthRadius >= radius && lng*math.Pi/180*earthRadius*math.Cos(point.Lat*math.Pi/180) >= radius {
+			precision = p
+			break
+		}
+	}
+
+	if precision == 0 {
+		return []string{""}
+	}
+
+	lat, lng := geoCellSize(precision)
+	for i := -1; i <= 1; i++ {
+		for j := -1; j <= 1; j++ {
+			p := appengine.GeoPoint{
+				Lat: math.Max(-90, math.Min(90, point.Lat+float64(i)*lat)),
+				Lng: point.Lng + float64(j)*lng,
+			}
+
+			// wrap around the antimeridian
+			if p.Lng > 180 {
+				p.Lng -= 360
+			} else if p.Lng < -180 {
+				p.Lng += 360
+			}
+
+			if c := geohash(p, precision); !hasProperty(cells, c) {
+				cells = append(cells, c)
+			}
+		}
+	}
+
+	return
+}
+
+// geoCellSize returns the height and width of the geohash cells of the given precision, in degrees
+func geoCellSize(precision int) (lat, lng float64) {
+	bits := 5 * precision
+	lat = 180 / math.Exp2(float64(bits/2))
+	lng = 360 / math.Exp2(float64(bits-bits/2))
+	return
+}
+
+// geohash returns the geohash of the point with the given number of characters
+func geohash(p appengine.GeoPoint, precision int) string {
+	lat := [2]float64{-90, 90}
+	lng := [2]float64{-180, 180}
+
+	hash := make([]byte, 0, precision)
+	even := true
+	bit, ch := 0, 0
+	for len(hash) < precision {
+		r, v := &lat, p.Lat
+		if even {
+			r, v = &lng, p.Lng
+		}
+
+		mid := (r[0] + r[1]) / 2
+		ch <<= 1
+		if v >= mid {
+			ch |= 1
+			r[0] = mid
+		} else {
+			r[1] = mid
+		}
+		even = !even
+
+		if bit++; bit == 5 {
+			hash = append(hash, geoBase32[ch])
+			bit, ch = 0, 0
+		}
+	}
+
+	return string(hash)
+}
+
+// haversine returns the great-circle distance between the points, in km
+func haversine(a, b appengine.GeoPoint) float64 {
+	lat1, lat2 := a.Lat*math.Pi/180, b.Lat*math.Pi/180
+	dLat := lat2 - lat1
+	dLng := (b.Lng - a.Lng) * math.Pi / 180
+
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLng/2)*math.Sin(dLng/2)
+
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
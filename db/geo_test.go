@@ -0,0 +1,103 @@
+package db
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+type Place struct {
+	base
+	Name     string
+	Location appengine.GeoPoint `db:",geo"`
+}
+
+func (m *Place) EntityType() string {
+	return "Place"
+}
+
+func (m *Place) PreSave(c context.Context) error {
+	if m.GetKey() == nil {
+		m.SetIsNew(true)
+		m.SetKey(datastore.NewIncompleteKey(c, m.EntityType(), nil))
+	}
+	return nil
+}
+
+func TestGeohash(t *testing.T) {
+	if got := geohash(appengine.GeoPoint{Lat: 57.64911, Lng: 10.40744}, 11); got != "u4pruydqqvj" {
+		t.Fatalf("geohash returned the wrong hash. Wanted: u4pruydqqvj; Got: %s", got)
+	}
+
+	// Paris to London is about 344km
+	d := haversine(appengine.GeoPoint{Lat: 48.8566, Lng: 2.3522}, appengine.GeoPoint{Lat: 51.5074, Lng: -0.1278})
+	if math.Abs(d-344) > 2 {
+		t.Fatalf("haversine returned the wrong distance. Wanted: ~344; Got: %f", d)
+	}
+
+	cells := geoCover(appengine.GeoPoint{Lat: 48.8566, Lng: 2.3522}, 1)
+	if len(cells) != 9 || len(cells[0]) != 5 {
+		t.Fatalf("geoCover returned the wrong cells. Got: %v", cells)
+	}
+
+	if cells = geoCover(appengine.GeoPoint{}, 20000); len(cells) != 1 || cells[0] != "" {
+		t.Fatalf("geoCover did not fall back to the whole kind. Got: %v", cells)
+	}
+}
+
+func TestNear(t *testing.T) {
+	defer ResetDB()
+	ctx := GetCtx()
+
+	RegisterKind(new(Place).EntityType(), func() Model { return new(Place) })
+
+	places := []*Place{
+		{Name: "Eiffel Tower", Location: appengine.GeoPoint{Lat: 48.8584, Lng: 2.2945}},
+		{Name: "Notre Dame", Location: appengine.GeoPoint{Lat: 48.8530, Lng: 2.3499}},
+		{Name: "Big Ben", Location: appengine.GeoPoint{Lat: 51.5007, Lng: -0.1246}},
+	}
+	for _, p := range places {
+		if err := Save(ctx, p); err != nil {
+			t.Fatalf("Save threw an error. Error: %v", err)
+		}
+
+		// perform an ancestor query to force the index to be applied so it's available in queries
+		if _, err := datastore.NewQuery(GeoIndexKind).Ancestor(p.GetKey()).KeysOnly().GetAll(ctx, nil); err != nil {
+			t.Fatalf("Could not query the geo index. Error: %v", err)
+		}
+	}
+
+	louvre := appengine.GeoPoint{Lat: 48.8606, Lng: 2.3376}
+	models, err := Near(ctx, "Place", louvre, 10)
+	if err != nil {
+		t.Fatalf("Near threw an error. Error: %v", err)
+	}
+	if len(models) != 2 {
+		t.Fatalf("Near returned the wrong number of models. Wanted: 2; Got: %d", len(models))
+	}
+
+	// closest first
+	if models[0].(*Place).Name != "Notre Dame" || models[1].(*Place).Name != "Eiffel Tower" {
+		t.Fatalf("Near returned the models in the wrong order. Got: %s, %s", models[0].(*Place).Name, models[1].(*Place).Name)
+	}
+
+	if models, err = Near(ctx, "Place", louvre, 500); err != nil || len(models) != 3 {
+		t.Fatalf("Near did not find every place within 500km. Error: %v; Got: %d", err, len(models))
+	}
+
+	// moving a place updates its cells
+	places[2].Location = appengine.GeoPoint{Lat: 48.8600, Lng: 2.3400}
+	if err = Save(ctx, places[2]); err != nil {
+		t.Fatalf("Save threw an error. Error: %v", err)
+	}
+	if _, err = datastore.NewQuery(GeoIndexKind).Ancestor(places[2].GetKey()).KeysOnly().GetAll(ctx, nil); err != nil {
+		t.Fatalf("Could not query the geo index. Error: %v", err)
+	}
+
+	if models, err = Near(ctx, "Place", louvre, 1); err != nil || len(models) != 1 || models[0].(*Place).Name != "Big Ben" {
+		t.Fatalf("Near did not find the moved place. Error: %v; Got: %v", err, models)
+	}
+}
@@ -0,0 +1,153 @@
+package db
+
+import (
+	"context"
+	"sync"
+
+	"google.golang.org/appengine/datastore"
+)
+
+// IDAllocator reserves IDs for entities before they are saved
+type IDAllocator interface {
+	// AllocateIDs reserves n consecutive IDs of the given kind under the given parent (which can be nil)
+	// and returns the lowest one
+	AllocateIDs(ctx context.Context, kind string, parent *datastore.Key, n int) (low int64, err error)
+}
+
+// DatastoreIDs is the IDAllocator that reserves IDs in the datastore, it is the default
+type DatastoreIDs struct{}
+
+// AllocateIDs satisfies the IDAllocator interface
+func (DatastoreIDs) AllocateIDs(ctx context.Context, kind string, parent *datastore.Key, n int) (low int64, err error) {
+	low, _, err = datastore.AllocateIDs(ctx, kind, parent, n)
+	return
+}
+
+// MemoryIDs is an IDAllocator that counts IDs up in memory, per kind and parent
+//
+// The IDs are only unique within the process and don't keep the datastore from handing them out again,
+// so it is meant for tests and tools that don't save entities with incomplete keys.
+type MemoryIDs struct {
+	mu   sync.Mutex
+	last map[string]int64
+}
+
+// AllocateIDs satisfies the IDAllocator interface
+func (m *MemoryIDs) AllocateIDs(ctx context.Context, kind string, parent *datastore.Key, n int) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.last == nil {
+		m.last = make(map[string]int64)
+	}
+
+	group := kind
+	if parent != nil {
+		group += "|" + parent.Encode()
+	}
+
+	low := m.last[group] + 1
+	m.last[group] += int64(n)
+
+	return low, nil
+}
+
+var (
+	idAllocatorMu sync.RWMutex
+	idAllocator   IDAllocator = DatastoreIDs{}
+)
+
+// SetIDAllocator sets the IDAllocator used by AllocateIDs and AssignKeys, nil sets DatastoreIDs again
+func SetIDAllocator(a IDAllocator) {
+	idAllocatorMu.Lock()
+	defer idAllocatorMu.Unlock()
+
+	if a == nil {
+		a = DatastoreIDs{}
+	}
+	idAllocator = a
+}
+
+// getIDAllocator returns the IDAllocator set with SetIDAllocator
+func getIDAllocator() IDAllocator {
+	idAllocatorMu.RLock()
+	defer idAllocatorMu.RUnlock()
+
+	return idAllocator
+}
+
+// AllocateIDs reserves n IDs of the given kind under the given parent (which can be nil)
+// and returns their complete keys, so related entities can reference each other before being saved
+// The IDs come from the IDAllocator set with SetIDAllocator.
+func AllocateIDs(ctx context.Context, kind string, n int, parent *datastore.Key) (keys []*datastore.Key, myerr error) {
+	if n <= 0 {
+		return
+	}
+
+	a := getIDAllocator()
+
+	var low int64
+	myerr = retry(ctx, true, func() (err error) {
+		low, err = a.AllocateIDs(ctx, kind, parent, n)
+		return
+	})
+	if myerr != nil {
+		return
+	}
+
+	keys = make([]*datastore.Key, n)
+	for i := range keys {
+		keys[i] = datastore.NewKey(ctx, kind, "", low+int64(i), parent)
+	}
+
+	return
+}
+
+// AssignKeys gives every model without a complete key an allocated one, before SaveMulti
+// Models with an incomplete key keep its parent, models without a key get the given parent (which can be nil)
+func AssignKeys(ctx context.Context, models []Model, parent *datastore.Key) (myerr error) {
+	type group struct {
+		kind   string
+		parent *datastore.Key
+		idx    []int
+	}
+
+	var groups []*group
+	for i, m := range models {
+		p := parent
+		if k := m.GetKey(); k != nil {
+			if !k.Incomplete() {
+				continue
+			}
+			p = k.Parent()
+		}
+
+		var g *group
+		for _, gg := range groups {
+			if gg.kind == m.EntityType() && gg.parent.Equal(p) {
+				g = gg
+				break
+			}
+		}
+		if g == nil {
+			g = &group{kind: m.EntityType(), parent: p}
+			groups = append(groups, g)
+		}
+		g.idx = append(g.idx, i)
+	}
+
+	for _, g := range groups {
+		var keys []*datastore.Key
+		if keys, myerr = AllocateIDs(ctx, g.kind, len(g.idx), g.parent); myerr != nil {
+			return
+		}
+
+		for j, i := range g.idx {
+			if myerr = models[i].SetKey(keys[j]); myerr != nil {
+				return
+			}
+		}
+	}
+
+	return
+}
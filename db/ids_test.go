@@ -0,0 +1,96 @@
+package db
+
+import (
+	"testing"
+
+	"google.golang.org/appengine/datastore"
+)
+
+func TestAllocateIDs(t *testing.T) {
+	defer ResetDB()
+	ctx := GetCtx()
+
+	parent := datastore.NewKey(ctx, "Parent", "p", 0, nil)
+	keys, err := AllocateIDs(ctx, "Foo", 3, parent)
+	if err != nil {
+		t.Fatalf("AllocateIDs threw an error. Error: %v", err)
+	}
+	if len(keys) != 3 {
+		t.Fatalf("AllocateIDs returned the wrong number of keys. Wanted: 3; Got: %d", len(keys))
+	}
+
+	seen := make(map[int64]bool)
+	for _, k := range keys {
+		if k.Incomplete() || k.Kind() != "Foo" || !k.Parent().Equal(parent) || seen[k.IntID()] {
+			t.Fatalf("AllocateIDs returned a wrong key. Got: %v", k)
+		}
+		seen[k.IntID()] = true
+	}
+
+	if keys, err = AllocateIDs(ctx, "Foo", 0, nil); err != nil || keys != nil {
+		t.Fatalf("AllocateIDs did not return nothing for 0 IDs. Error: %v; Got: %v", err, keys)
+	}
+}
+
+func TestAllocateIDsMemory(t *testing.T) {
+	ctx := GetCtx()
+
+	SetIDAllocator(&MemoryIDs{})
+	defer SetIDAllocator(nil)
+
+	parent := datastore.NewKey(ctx, "Parent", "p", 0, nil)
+	keys, err := AllocateIDs(ctx, "Foo", 2, parent)
+	if err != nil {
+		t.Fatalf("AllocateIDs threw an error. Error: %v", err)
+	}
+	if keys[0].IntID() != 1 || keys[1].IntID() != 2 || !keys[1].Parent().Equal(parent) {
+		t.Fatalf("AllocateIDs returned the wrong keys. Got: %v", keys)
+	}
+
+	// every kind and parent counts on its own
+	more, err := AllocateIDs(ctx, "Foo", 1, parent)
+	if err != nil || more[0].IntID() != 3 {
+		t.Fatalf("AllocateIDs handed out an ID again. Error: %v; Got: %v", err, more)
+	}
+
+	other, err := AllocateIDs(ctx, "Foo", 1, nil)
+	if err != nil || other[0].IntID() != 1 || other[0].Parent() != nil {
+		t.Fatalf("AllocateIDs did not count another parent on its own. Error: %v; Got: %v", err, other)
+	}
+}
+
+func TestAssignKeys(t *testing.T) {
+	defer ResetDB()
+	ctx := GetCtx()
+
+	parent := datastore.NewKey(ctx, "Parent", "p", 0, nil)
+	complete := datastore.NewKey(ctx, "Foo", "", 42, nil)
+
+	fs := []*Foo{{}, {}, {}}
+	fs[1].SetKey(complete)
+	fs[2].SetKey(datastore.NewIncompleteKey(ctx, "Foo", nil))
+
+	models := []Model{fs[0], fs[1], fs[2]}
+	if err := AssignKeys(ctx, models, parent); err != nil {
+		t.Fatalf("AssignKeys threw an error. Error: %v", err)
+	}
+
+	if k := fs[0].GetKey(); k == nil || k.Incomplete() || !k.Parent().Equal(parent) {
+		t.Fatalf("AssignKeys did not assign a key under the parent. Got: %v", k)
+	}
+	if !fs[1].GetKey().Equal(complete) {
+		t.Fatalf("AssignKeys changed a complete key. Got: %v", fs[1].GetKey())
+	}
+	if k := fs[2].GetKey(); k.Incomplete() || k.Parent() != nil {
+		t.Fatalf("AssignKeys did not keep the parent of an incomplete key. Got: %v", k)
+	}
+
+	// the assigned keys are kept on save
+	keys := []*datastore.Key{fs[0].GetKey(), fs[2].GetKey()}
+	if err := SaveMulti(ctx, models); err != nil {
+		t.Fatalf("SaveMulti threw an error. Error: %v", err)
+	}
+	if !fs[0].GetKey().Equal(keys[0]) || !fs[2].GetKey().Equal(keys[1]) {
+		t.Fatal("SaveMulti did not keep the assigned keys")
+	}
+}
@@ -0,0 +1,182 @@
+package db
+
+import (
+	"context"
+
+	"google.golang.org/appengine/datastore"
+)
+
+// maxBatchKeys is the most keys a single datastore call takes
+const maxBatchKeys = 500
+
+// indexer maintains companion entities for models with fields tagged for it,
+// they are written after the model is saved and removed when it is deleted
+type indexer interface {
+	// tag is the db struct tag option of the fields the indexer uses
+	tag() string
+
+	// kind is the kind of the companion entities
+	kind() string
+
+	// entities returns the companion keys and entities for the model with key k,
+	// given the names of its tagged properties and all of its (unencrypted) properties
+	entities(ctx context.Context, k *datastore.Key, names []string, pl []datastore.Property) ([]*datastore.Key, []interface{}, error)
+
+	// keys returns every companion key the model with key k could have
+	keys(ctx context.Context, k *datastore.Key, names []string) []*datastore.Key
+}
+
+var indexers []indexer
+
+// updateIndexes writes the companion entities of the saved models
+// and removes the ones they no longer have
+func updateIndexes(ctx context.Context, models []Model) (myerr error) {
+	var putKeys, delKeys []*datastore.Key
+	var putVals []interface{}
+
+	for _, idx := range indexers {
+		for _, m := range models {
+			names := indexedFields(m, idx.tag())
+			if len(names) == 0 {
+				continue
+			}
+
+			pl, err := modelProperties(m)
+			if err != nil {
+				return err
+			}
+
+			keys, vals, err := idx.entities(ctx, m.GetKey(), names, pl)
+			if err != nil {
+				return err
+			}
+
+			putKeys = append(putKeys, keys...)
+			putVals = append(putVals, vals...)
+
+			for _, k := range idx.keys(ctx, m.GetKey(), names) {
+				if !containsKey(keys, k) {
+					delKeys = append(delKeys, k)
+				}
+			}
+		}
+	}
+
+	if len(putKeys) > 0 {
+		myerr = retry(ctx, true, func() (err error) {
+			_, err = datastore.PutMulti(ctx, putKeys, putVals)
+			return
+		})
+		if myerr != nil {
+			return
+		}
+	}
+
+	if len(delKeys) > 0 {
+		myerr = retry(ctx, true, func() error {
+			return datastore.DeleteMulti(ctx, delKeys)
+		})
+	}
+
+	return
+}
+
+// removeIndexes removes the companion entities of the deleted model
+func removeIndexes(ctx context.Context, m Model) (myerr error) {
+	var keys []*datastore.Key
+	for _, idx := range indexers {
+		if names := indexedFields(m, idx.tag()); len(names) > 0 {
+			keys = append(keys, idx.keys(ctx, m.GetKey(), names)...)
+		}
+	}
+
+	return deleteIndexKeys(ctx, keys)
+}
+
+// removeIndexesK removes the companion entities of the deleted models with the given keys
+// Only registered kinds (see RegisterKind) tell which companion entities they have,
+// the ones of unregistered kinds are left behind and skipped by Search and Near.
+func removeIndexesK(ctx context.Context, keys []*datastore.Key) (myerr error) {
+	var idxKeys []*datastore.Key
+	for _, k := range keys {
+		if k == nil || isIndexKind(k.Kind()) {
+			continue
+		}
+
+		m, err := NewModel(k.Kind())
+		if err != nil {
+			continue
+		}
+
+		for _, idx := range indexers {
+			if names := indexedFields(m, idx.tag()); len(names) > 0 {
+				idxKeys = append(idxKeys, idx.keys(ctx, k, names)...)
+			}
+		}
+	}
+
+	return deleteIndexKeys(ctx, idxKeys)
+}
+
+// deleteIndexKeys deletes the given companion entities in chunks the datastore accepts
+func deleteIndexKeys(ctx context.Context, keys []*datastore.Key) (myerr error) {
+	for 0 < len(keys) {
+		chunk := keys
+		if len(chunk) > maxBatchKeys {
+			chunk = chunk[:maxBatchKeys]
+		}
+		keys = keys[len(chunk):]
+
+		myerr = retry(ctx, true, func() error {
+			return datastore.DeleteMulti(ctx, chunk)
+		})
+		if myerr != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// isIndexKind reports whether kind is the kind of the companion entities of an indexer
+func isIndexKind(kind string) bool {
+	for _, idx := range indexers {
+		if idx.kind() == kind {
+			return true
+		}
+	}
+
+	return false
+}
+
+// indexedFields returns the properties of m tagged with tag, except for encrypted ones
+// which never get copied into companion entities
+func indexedFields(m Model, tag string) (names []string) {
+	encrypted := taggedFields(m, tagEncrypted)
+	for _, n := range taggedFields(m, tag) {
+		if !hasProperty(encrypted, n) {
+			names = append(names, n)
+		}
+	}
+
+	return
+}
+
+// modelProperties returns the properties of m as they are before encryption
+func modelProperties(m Model) ([]datastore.Property, error) {
+	if pls, ok := m.(datastore.PropertyLoadSaver); ok {
+		return pls.Save()
+	}
+
+	return datastore.SaveStruct(m)
+}
+
+func containsKey(keys []*datastore.Key, k *datastore.Key) bool {
+	for _, v := range keys {
+		if v.Equal(k) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,174 @@
+package db
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The names of the operations as they are passed to a Tracer and Metrics
+const (
+	OpLoad        = "Load"
+	OpLoadMulti   = "LoadMulti"
+	OpSave        = "Save"
+	OpSaveMulti   = "SaveMulti"
+	OpDelete      = "Delete"
+	OpDeleteMulti = "DeleteMulti"
+	OpQuery       = "Query"
+)
+
+// The names of the metrics recorded for every operation
+const (
+	MetricOperations = "db.operations"  // counter: number of operations
+	MetricEntities   = "db.entities"    // counter: number of entities read or written
+	MetricErrors     = "db.errors"      // counter: number of failed operations
+	MetricDuration   = "db.duration_ms" // histogram: operation latency in milliseconds
+)
+
+// Tracer starts a span around every db operation
+//
+// It is a small subset of the OpenTelemetry trace API, so wrapping an
+// OpenTelemetry tracer only takes converting the attributes.
+type Tracer interface {
+	Start(ctx context.Context, name string, attrs ...slog.Attr) (context.Context, Span)
+}
+
+// Span is a single traced db operation
+type Span interface {
+	SetAttributes(attrs ...slog.Attr)
+	RecordError(err error)
+	End()
+}
+
+// Metrics records counters and histograms for every db operation
+// Every value is recorded with "db.operation" and "db.kind" attributes
+type Metrics interface {
+	Add(ctx context.Context, name string, n int64, attrs ...slog.Attr)
+	Record(ctx context.Context, name string, v float64, attrs ...slog.Attr)
+}
+
+// instruments holds the Tracer, Metrics and logger used for db operations
+// It is replaced as a whole by the setters, so operations that are running never see a partial change
+type instruments struct {
+	tracer  Tracer
+	metrics Metrics
+	logger  *slog.Logger
+}
+
+var (
+	instrumentsMu  sync.Mutex // serializes the setters
+	instrumentsPtr atomic.Pointer[instruments]
+)
+
+// loadInstruments returns the current instruments
+func loadInstruments() *instruments {
+	if i := instrumentsPtr.Load(); i != nil {
+		return i
+	}
+
+	return &instruments{tracer: noopTracer{}, metrics: noopMetrics{}}
+}
+
+// updateInstruments replaces the instruments with a copy of them changed by f
+func updateInstruments(f func(i *instruments)) {
+	instrumentsMu.Lock()
+	defer instrumentsMu.Unlock()
+
+	i := *loadInstruments()
+	f(&i)
+	instrumentsPtr.Store(&i)
+}
+
+// SetTracer sets the Tracer used for db operations, nil disables tracing
+func SetTracer(t Tracer) {
+	if t == nil {
+		t = noopTracer{}
+	}
+	updateInstruments(func(i *instruments) { i.tracer = t })
+}
+
+// SetMetrics sets the Metrics used for db operations, nil disables metrics
+func SetMetrics(m Metrics) {
+	if m == nil {
+		m = noopMetrics{}
+	}
+	updateInstruments(func(i *instruments) { i.metrics = m })
+}
+
+// SetLogger sets the logger used for db operations, nil uses slog.Default()
+func SetLogger(l *slog.Logger) {
+	updateInstruments(func(i *instruments) { i.logger = l })
+}
+
+func getLogger() *slog.Logger {
+	if l := loadInstruments().logger; l != nil {
+		return l
+	}
+	return slog.Default()
+}
+
+// operation is a single instrumented db operation
+type operation struct {
+	ctx   context.Context
+	name  string
+	kind  string
+	start time.Time
+	span  Span
+	inst  *instruments
+}
+
+// startOp starts instrumenting the named operation and returns the context to run it with
+func startOp(ctx context.Context, name string, kind string) (context.Context, *operation) {
+	op := &operation{
+		name:  name,
+		kind:  kind,
+		start: time.Now(),
+		inst:  loadInstruments(),
+	}
+
+	op.ctx, op.span = op.inst.tracer.Start(ctx, "db."+name, slog.String("db.operation", name))
+
+	return op.ctx, op
+}
+
+// end finishes the operation after n entities were processed
+func (op *operation) end(n int, err error) {
+	attrs := []slog.Attr{
+		slog.String("db.operation", op.name),
+		slog.String("db.kind", op.kind),
+	}
+
+	metrics := op.inst.metrics
+	metrics.Add(op.ctx, MetricOperations, 1, attrs...)
+	metrics.Add(op.ctx, MetricEntities, int64(n), attrs...)
+	metrics.Record(op.ctx, MetricDuration, float64(time.Since(op.start))/float64(time.Millisecond), attrs...)
+
+	op.span.SetAttributes(slog.String("db.kind", op.kind), slog.Int("db.entities", n))
+
+	if err != nil {
+		metrics.Add(op.ctx, MetricErrors, 1, attrs...)
+		op.span.RecordError(err)
+		getLogger().LogAttrs(op.ctx, slog.LevelInfo, op.name+" failed", append(attrs, slog.Any("error", err))...)
+	}
+
+	op.span.End()
+}
+
+type noopTracer struct{}
+
+func (noopTracer) Start(ctx context.Context, name string, attrs ...slog.Attr) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttributes(attrs ...slog.Attr) {}
+func (noopSpan) RecordError(err error)            {}
+func (noopSpan) End()                             {}
+
+type noopMetrics struct{}
+
+func (noopMetrics) Add(ctx context.Context, name string, n int64, attrs ...slog.Attr)      {}
+func (noopMetrics) Record(ctx context.Context, name string, v float64, attrs ...slog.Attr) {}
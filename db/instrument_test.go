@@ -0,0 +1,196 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+
+	"google.golang.org/appengine/datastore"
+)
+
+type testSpan struct {
+	name  string
+	attrs map[string]string
+	err   error
+	ended bool
+}
+
+func (s *testSpan) SetAttributes(attrs ...slog.Attr) {
+	for _, a := range attrs {
+		s.attrs[a.Key] = a.Value.String()
+	}
+}
+
+func (s *testSpan) RecordError(err error) {
+	s.err = err
+}
+
+func (s *testSpan) End() {
+	s.ended = true
+}
+
+type testTracer struct {
+	spans []*testSpan
+}
+
+func (t *testTracer) Start(ctx context.Context, name string, attrs ...slog.Attr) (context.Context, Span) {
+	s := &testSpan{name: name, attrs: make(map[string]string)}
+	s.SetAttributes(attrs...)
+	t.spans = append(t.spans, s)
+	return ctx, s
+}
+
+type testMetrics struct {
+	counts map[string]int64
+	values map[string]int
+}
+
+func (m *testMetrics) Add(ctx context.Context, name string, n int64, attrs ...slog.Attr) {
+	m.counts[name+":"+attrs[1].Value.String()] += n
+}
+
+func (m *testMetrics) Record(ctx context.Context, name string, v float64, attrs ...slog.Attr) {
+	m.values[name+":"+attrs[1].Value.String()]++
+}
+
+func TestInstrumentation(t *testing.T) {
+	defer ResetDB()
+	ctx := GetCtx()
+
+	tr := &testTracer{}
+	me := &testMetrics{counts: make(map[string]int64), values: make(map[string]int)}
+	var buf bytes.Buffer
+
+	SetTracer(tr)
+	SetMetrics(me)
+	SetLogger(slog.New(slog.NewTextHandler(&buf, nil)))
+	defer func() {
+		SetTracer(nil)
+		SetMetrics(nil)
+		SetLogger(nil)
+	}()
+
+	p := createFoo(ctx, t)
+
+	var m Foo
+	if _, err := Load(ctx, p.GetKey(), &m); err != nil {
+		t.Fatalf("Load threw an error. Error: %v", err)
+	}
+
+	// a failing load
+	Load(ctx, datastore.NewKey(ctx, new(Foo).EntityType(), "", 100, nil), &m)
+
+	if len(tr.spans) != 3 {
+		t.Fatalf("Tracer did not get the correct number of spans. Wanted: 3; Got: %d", len(tr.spans))
+	}
+
+	for _, s := range tr.spans {
+		if !s.ended {
+			t.Fatalf("span %s was not ended", s.name)
+		}
+		if s.attrs["db.kind"] != "Foo" {
+			t.Fatalf("span %s has the wrong kind. Wanted: Foo; Got: %s", s.name, s.attrs["db.kind"])
+		}
+	}
+
+	if tr.spans[0].name != "db.Save" || tr.spans[1].name != "db.Load" {
+		t.Fatalf("Tracer got spans with the wrong names. Got: %s, %s", tr.spans[0].name, tr.spans[1].name)
+	}
+
+	if tr.spans[2].err == nil {
+		t.Fatal("span did not record the error of a failing Load")
+	}
+
+	if me.counts[MetricOperations+":Foo"] != 3 {
+		t.Fatalf("Metrics counted the wrong number of operations. Wanted: 3; Got: %d", me.counts[MetricOperations+":Foo"])
+	}
+	if me.counts[MetricEntities+":Foo"] != 2 {
+		t.Fatalf("Metrics counted the wrong number of entities. Wanted: 2; Got: %d", me.counts[MetricEntities+":Foo"])
+	}
+	if me.counts[MetricErrors+":Foo"] != 1 {
+		t.Fatalf("Metrics counted the wrong number of errors. Wanted: 1; Got: %d", me.counts[MetricErrors+":Foo"])
+	}
+	if me.values[MetricDuration+":Foo"] != 3 {
+		t.Fatalf("Metrics recorded the wrong number of durations. Wanted: 3; Got: %d", me.values[MetricDuration+":Foo"])
+	}
+
+	if !strings.Contains(buf.String(), "Load failed") {
+		t.Fatalf("Logger did not log the failing Load. Got: %s", buf.String())
+	}
+}
+
+func TestInstrumentDeleteMulti(t *testing.T) {
+	defer ResetDB()
+	ctx := GetCtx()
+
+	a, b := createFoo(ctx, t), createFoo(ctx, t)
+	keys := []*datastore.Key{a.GetKey(), b.GetKey()}
+
+	tr := &testTracer{}
+	SetTracer(tr)
+	defer SetTracer(nil)
+
+	if err := DeleteMultiK(ctx, keys); err != nil {
+		t.Fatalf("DeleteMultiK threw an error. Error: %v", err)
+	}
+
+	if len(tr.spans) != 1 || tr.spans[0].name != "db.DeleteMulti" {
+		t.Fatalf("Tracer did not get a single DeleteMulti span. Got: %d spans", len(tr.spans))
+	}
+
+	if tr.spans[0].attrs["db.entities"] != "2" {
+		t.Fatalf("DeleteMulti reported the wrong number of entities. Wanted: 2; Got: %s", tr.spans[0].attrs["db.entities"])
+	}
+}
+
+func TestSetInstrumentsConcurrent(t *testing.T) {
+	defer func() {
+		SetTracer(nil)
+		SetMetrics(nil)
+		SetLogger(nil)
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			SetTracer(nil)
+			SetMetrics(nil)
+			SetLogger(slog.Default())
+		}()
+		go func() {
+			defer wg.Done()
+			_, op := startOp(context.Background(), OpLoad, "Foo")
+			op.end(0, nil)
+		}()
+	}
+	wg.Wait()
+}
+
+func TestQuery(t *testing.T) {
+	defer ResetDB()
+	ctx := GetCtx()
+
+	for i := 0; i < 3; i++ {
+		createFoo(ctx, t)
+	}
+
+	var foos []*Foo
+	keys, err := Query(ctx, datastore.NewQuery(new(Foo).EntityType()), &foos)
+	if err != nil {
+		t.Fatalf("Query threw an error. Error: %v", err)
+	}
+	if len(keys) != 3 || len(foos) != 3 {
+		t.Fatalf("Query returned the wrong number of results. Wanted: 3; Got: %d", len(foos))
+	}
+
+	for i, f := range foos {
+		if !f.GetKey().Equal(keys[i]) {
+			t.Fatal("Query did not set the keys of the loaded models")
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+var (
+	kindsMu sync.RWMutex
+	kinds   = make(map[string]func() Model)
+)
+
+// RegisterKind registers a func that returns a new, empty Model of the given kind
+// so db can create Models for kinds it only knows by name (e.g.: in Search)
+func RegisterKind(kind string, fn func() Model) {
+	kindsMu.Lock()
+	defer kindsMu.Unlock()
+
+	kinds[kind] = fn
+}
+
+// NewModel returns a new, empty Model of the given registered kind
+func NewModel(kind string) (Model, error) {
+	kindsMu.RLock()
+	fn, ok := kinds[kind]
+	kindsMu.RUnlock()
+
+	if !ok {
+		return nil, &UnregisteredKindError{Kind: kind}
+	}
+
+	return fn(), nil
+}
+
+// newModels returns n new, empty Models of the given registered kind
+func newModels(kind string, n int) (models []Model, myerr error) {
+	models = make([]Model, n)
+	for i := range models {
+		if models[i], myerr = NewModel(kind); myerr != nil {
+			return nil, myerr
+		}
+	}
+
+	return
+}
+
+// loadExisting loads the models of the given registered kind with the given keys, in order,
+// skipping the ones that no longer exist
+func loadExisting(ctx context.Context, kind string, keys []*datastore.Key) (models []Model, myerr error) {
+	for {
+		if models, myerr = newModels(kind, len(keys)); myerr != nil {
+			return
+		}
+
+		if _, myerr = LoadMulti(ctx, keys, models); myerr == nil {
+			return
+		}
+
+		var me appengine.MultiError
+		if !errors.Is(myerr, ErrNotFound) || !errors.As(myerr, &me) || len(me) != len(keys) {
+			models = nil
+			return
+		}
+
+		found := make([]*datastore.Key, 0, len(keys))
+		for i, err := range me {
+			if !isNotFound(err) {
+				found = append(found, keys[i])
+			}
+		}
+		keys = found
+	}
+}
@@ -0,0 +1,173 @@
+package db
+
+import (
+	"context"
+	"reflect"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+// PartialModel is a Model that can be loaded with only some of its properties (see LoadPartial)
+// Save and SaveMulti refuse to save a partial model, as it would overwrite the missing properties
+type PartialModel interface {
+	Model
+	IsPartial() bool
+	SetPartial(bool)
+}
+
+// Partial implements the PartialModel methods, embed it in a model with a `datastore:"-"` tag
+type Partial struct {
+	partial bool
+}
+
+func (p *Partial) IsPartial() bool {
+	return p.partial
+}
+
+func (p *Partial) SetPartial(partial bool) {
+	p.partial = partial
+}
+
+// loadedFully clears the partial flag of a model that got all of its properties loaded
+func loadedFully(m Model) {
+	if pm, ok := m.(PartialModel); ok {
+		pm.SetPartial(false)
+	}
+}
+
+// LoadPartial loads only the named properties of the entity with the given key into m and marks it as partial
+//
+// It runs a projection query, so the properties have to be indexed (encrypted properties never are),
+// projecting several properties needs a composite index, and the values come back as they are stored in the index.
+// A multi-valued property gets its distinct values.
+func LoadPartial(ctx context.Context, k *datastore.Key, m PartialModel, names ...string) (found bool, myerr error) {
+	ctx, op := startOp(ctx, OpLoad, m.EntityType())
+	defer func() {
+		n := 0
+		if found {
+			n = 1
+		}
+		op.end(n, myerr)
+	}()
+
+	pl, myerr := loadProjection(ctx, k, names)
+	if myerr != nil {
+		return
+	}
+
+	if pl == nil {
+		myerr = &UnfoundObjectError{
+			EntityType: m.EntityType(),
+			Key:        "key",
+			Value:      k.Encode(),
+			Err:        datastore.ErrNoSuchEntity,
+		}
+		return
+	}
+
+	found = true
+
+	if myerr = (&codec{ctx: ctx, m: m}).Load(pl); myerr != nil {
+		if !IsFieldMismatch(myerr) {
+			return
+		}
+		myerr = nil
+	}
+
+	m.SetPartial(true)
+
+	if myerr = m.SetKey(k); myerr != nil {
+		return
+	}
+
+	if myerr = m.PostLoad(ctx); myerr != nil {
+		return
+	}
+
+	return
+}
+
+// LoadProperties returns only the named properties of the entity with the given key
+// It has the same limits as LoadPartial, and returns an UnfoundObjectError if the entity doesn't exist
+func LoadProperties(ctx context.Context, k *datastore.Key, names ...string) (pl datastore.PropertyList, myerr error) {
+	ctx, op := startOp(ctx, OpLoad, k.Kind())
+	defer func() {
+		n := 0
+		if pl != nil {
+			n = 1
+		}
+		op.end(n, myerr)
+	}()
+
+	if pl, myerr = loadProjection(ctx, k, names); myerr == nil && pl == nil {
+		myerr = &UnfoundObjectError{
+			EntityType: k.Kind(),
+			Key:        "key",
+			Value:      k.Encode(),
+			Err:        datastore.ErrNoSuchEntity,
+		}
+	}
+
+	return
+}
+
+// loadProjection runs a projection query for the entity with the given key
+// and merges the results, it returns nil if the entity doesn't exist
+func loadProjection(ctx context.Context, k *datastore.Key, names []string) (pl datastore.PropertyList, myerr error) {
+	// the key filter has to be in the namespace of the key
+	nctx, myerr := appengine.Namespace(ctx, k.Namespace())
+	if myerr != nil {
+		return
+	}
+
+	q := datastore.NewQuery(k.Kind()).Filter("__key__ =", k).Project(names...)
+
+	var rows []datastore.PropertyList
+	myerr = retry(ctx, true, func() (err error) {
+		rows = rows[:0]
+		_, err = q.GetAll(nctx, &rows)
+		return
+	})
+	if myerr != nil || len(rows) == 0 {
+		return
+	}
+
+	// a multi-valued property gives a row per value
+	pl = datastore.PropertyList{}
+	counts := make(map[string]int)
+	for _, row := range rows {
+		for _, p := range row {
+			if !containsProperty(pl, p) {
+				pl = append(pl, p)
+				counts[p.Name]++
+			}
+		}
+	}
+
+	for i := range pl {
+		pl[i].Multiple = counts[pl[i].Name] > 1
+	}
+
+	return
+}
+
+// containsProperty reports whether pl holds a property with the name and value of p
+func containsProperty(pl datastore.PropertyList, p datastore.Property) bool {
+	for _, q := range pl {
+		if q.Name == p.Name && reflect.DeepEqual(q.Value, p.Value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkPartial returns a PartialSaveError if m was partially loaded
+func checkPartial(m Model) error {
+	if p, ok := m.(PartialModel); ok && p.IsPartial() {
+		return &PartialSaveError{EntityType: m.EntityType()}
+	}
+
+	return nil
+}
@@ -0,0 +1,110 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"google.golang.org/appengine/datastore"
+)
+
+type Profile struct {
+	base
+	Partial `datastore:"-"`
+	Name    string
+	Tags    []string
+	Bio     string `datastore:",noindex"`
+}
+
+func (m *Profile) EntityType() string {
+	return "Profile"
+}
+
+func (m *Profile) PreSave(c context.Context) error {
+	if m.GetKey() == nil {
+		m.SetIsNew(true)
+		m.SetKey(datastore.NewIncompleteKey(c, m.EntityType(), nil))
+	}
+	return nil
+}
+
+func TestLoadPartial(t *testing.T) {
+	defer ResetDB()
+	ctx := GetCtx()
+
+	p := &Profile{Name: "partial", Tags: []string{"a", "b"}, Bio: "a long bio"}
+	if err := Save(ctx, p); err != nil {
+		t.Fatalf("Save threw an error. Error: %v", err)
+	}
+
+	var got Profile
+	found, err := LoadPartial(ctx, p.GetKey(), &got, "Name")
+	if err != nil || !found {
+		t.Fatalf("LoadPartial threw an error. Error: %v", err)
+	}
+	if got.Name != "partial" || got.Bio != "" || !got.IsPartial() {
+		t.Fatalf("LoadPartial loaded the wrong properties. Got: %+v", got)
+	}
+	if !got.GetKey().Equal(p.GetKey()) {
+		t.Fatal("LoadPartial did not set the key")
+	}
+
+	if err = Save(ctx, &got); err == nil {
+		t.Fatal("Save did not refuse a partial model")
+	} else if _, ok := err.(*PartialSaveError); !ok {
+		t.Fatalf("Save threw the wrong error. Got: %T: %v", err, err)
+	}
+
+	if err = SaveMulti(ctx, []Model{p, &got}); err == nil {
+		t.Fatal("SaveMulti did not refuse a partial model")
+	}
+
+	// a multi-valued property gets all of its values
+	pl, err := LoadProperties(ctx, p.GetKey(), "Tags")
+	if err != nil {
+		t.Fatalf("LoadProperties threw an error. Error: %v", err)
+	}
+	if len(pl) != 2 || !pl[0].Multiple {
+		t.Fatalf("LoadProperties returned the wrong properties. Got: %+v", pl)
+	}
+
+	missing := datastore.NewKey(ctx, "Profile", "", 12345, nil)
+	if _, err = LoadProperties(ctx, missing, "Name"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("LoadProperties did not throw ErrNotFound. Got: %v", err)
+	}
+	if found, err = LoadPartial(ctx, missing, &Profile{}, "Name"); found || err == nil {
+		t.Fatal("LoadPartial did not throw an error for a missing entity")
+	}
+}
+
+func TestLoadPartialThenFull(t *testing.T) {
+	defer ResetDB()
+	ctx := GetCtx()
+
+	p := &Profile{Name: "partial", Bio: "a long bio"}
+	if err := Save(ctx, p); err != nil {
+		t.Fatalf("Save threw an error. Error: %v", err)
+	}
+
+	var got Profile
+	if _, err := LoadPartial(ctx, p.GetKey(), &got, "Name"); err != nil {
+		t.Fatalf("LoadPartial threw an error. Error: %v", err)
+	}
+
+	// a full load makes it whole again
+	if _, err := Load(ctx, p.GetKey(), &got); err != nil {
+		t.Fatalf("Load threw an error. Error: %v", err)
+	}
+	if got.IsPartial() || got.Bio != "a long bio" {
+		t.Fatalf("Load did not clear the partial flag. Got: %+v", got)
+	}
+	if err := Save(ctx, &got); err != nil {
+		t.Fatalf("Save refused a fully loaded model. Error: %v", err)
+	}
+
+	// and so does LoadMulti
+	got.SetPartial(true)
+	if _, err := LoadMulti(ctx, []*datastore.Key{p.GetKey()}, []Model{&got}); err != nil || got.IsPartial() {
+		t.Fatalf("LoadMulti did not clear the partial flag. Error: %v", err)
+	}
+}
@@ -0,0 +1,213 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"google.golang.org/appengine/datastore"
+)
+
+// Repo is a typed wrapper around the db functions for a single Model type
+// so callers get concrete types back instead of Models
+//
+// T has to be a pointer to a struct, e.g.: db.NewRepo[*User]()
+type Repo[T Model] struct {
+	kind string
+	typ  reflect.Type
+}
+
+// NewRepo returns a Repo for the Model type T and registers its kind (see RegisterKind)
+func NewRepo[T Model]() *Repo[T] {
+	var zero T
+	typ := reflect.TypeOf(zero)
+	if typ == nil || typ.Kind() != reflect.Ptr || typ.Elem().Kind() != reflect.Struct {
+		panic(fmt.Sprintf("db.NewRepo: %v is not a pointer to a struct", typ))
+	}
+
+	r := &Repo[T]{typ: typ.Elem()}
+	r.kind = r.New().EntityType()
+
+	RegisterKind(r.kind, func() Model { return r.New() })
+
+	return r
+}
+
+// New returns a new, empty T
+func (r *Repo[T]) New() T {
+	return reflect.New(r.typ).Interface().(T)
+}
+
+// Kind returns the entity type of T
+func (r *Repo[T]) Kind() string {
+	return r.kind
+}
+
+// Get loads the T with the given ID
+func (r *Repo[T]) Get(ctx context.Context, id int64) (m T, myerr error) {
+	m = r.New()
+	if _, myerr = LoadInt(ctx, id, m); myerr != nil {
+		var zero T
+		m = zero
+	}
+
+	return
+}
+
+// GetKey loads the T with the given key
+func (r *Repo[T]) GetKey(ctx context.Context, k *datastore.Key) (m T, myerr error) {
+	m = r.New()
+	if _, myerr = Load(ctx, k, m); myerr != nil {
+		var zero T
+		m = zero
+	}
+
+	return
+}
+
+// GetS loads the T with the given encoded key
+func (r *Repo[T]) GetS(ctx context.Context, sk string) (m T, myerr error) {
+	m = r.New()
+	if _, myerr = LoadS(ctx, sk, m); myerr != nil {
+		var zero T
+		m = zero
+	}
+
+	return
+}
+
+// GetMulti loads the Ts with the given IDs
+func (r *Repo[T]) GetMulti(ctx context.Context, ids []int64) ([]T, error) {
+	keys := make([]*datastore.Key, len(ids))
+	for i, id := range ids {
+		keys[i] = datastore.NewKey(ctx, r.kind, "", id, nil)
+	}
+
+	return r.GetMultiKeys(ctx, keys)
+}
+
+// GetMultiKeys loads the Ts with the given keys
+func (r *Repo[T]) GetMultiKeys(ctx context.Context, keys []*datastore.Key) (ms []T, myerr error) {
+	ms = make([]T, len(keys))
+	models := make([]Model, len(keys))
+	for i := range ms {
+		ms[i] = r.New()
+		models[i] = ms[i]
+	}
+
+	if _, myerr = LoadMulti(ctx, keys, models); myerr != nil {
+		ms = nil
+	}
+
+	return
+}
+
+// Put saves m
+func (r *Repo[T]) Put(ctx context.Context, m T) error {
+	return Save(ctx, m)
+}
+
+// PutMulti saves all of ms
+func (r *Repo[T]) PutMulti(ctx context.Context, ms []T) error {
+	models := make([]Model, len(ms))
+	for i, m := range ms {
+		models[i] = m
+	}
+
+	return SaveMulti(ctx, models)
+}
+
+// Delete deletes m
+func (r *Repo[T]) Delete(ctx context.Context, m T) error {
+	return Delete(ctx, m)
+}
+
+// Query returns a new query for all Ts
+func (r *Repo[T]) Query() *RepoQuery[T] {
+	return &RepoQuery[T]{
+		r: r,
+		q: datastore.NewQuery(r.kind),
+	}
+}
+
+// RepoQuery is a query for Ts
+// Like datastore.Query, every method returns a new, changed copy
+type RepoQuery[T Model] struct {
+	r *Repo[T]
+	q *datastore.Query
+}
+
+// Filter returns a derivative query with a field-based filter (see datastore.Query.Filter)
+func (rq *RepoQuery[T]) Filter(filterStr string, value interface{}) *RepoQuery[T] {
+	return &RepoQuery[T]{r: rq.r, q: rq.q.Filter(filterStr, value)}
+}
+
+// Order returns a derivative query with a field-based sort order (see datastore.Query.Order)
+func (rq *RepoQuery[T]) Order(fieldName string) *RepoQuery[T] {
+	return &RepoQuery[T]{r: rq.r, q: rq.q.Order(fieldName)}
+}
+
+// Ancestor returns a derivative query with an ancestor filter
+func (rq *RepoQuery[T]) Ancestor(ancestor *datastore.Key) *RepoQuery[T] {
+	return &RepoQuery[T]{r: rq.r, q: rq.q.Ancestor(ancestor)}
+}
+
+// Limit returns a derivative query that has a limit on the number of results returned
+func (rq *RepoQuery[T]) Limit(limit int) *RepoQuery[T] {
+	return &RepoQuery[T]{r: rq.r, q: rq.q.Limit(limit)}
+}
+
+// Offset returns a derivative query that has an offset of how many results to skip over
+func (rq *RepoQuery[T]) Offset(offset int) *RepoQuery[T] {
+	return &RepoQuery[T]{r: rq.r, q: rq.q.Offset(offset)}
+}
+
+// All runs the query and returns every matching T
+func (rq *RepoQuery[T]) All(ctx context.Context) (ms []T, myerr error) {
+	if _, myerr = Query(ctx, rq.q, &ms); myerr != nil {
+		ms = nil
+	}
+
+	return
+}
+
+// First runs the query and returns the first matching T
+func (rq *RepoQuery[T]) First(ctx context.Context) (m T, myerr error) {
+	ms, myerr := rq.Limit(1).All(ctx)
+	if myerr != nil {
+		return
+	}
+
+	if len(ms) == 0 {
+		myerr = &UnfoundObjectError{
+			EntityType: rq.r.kind,
+			Key:        "query",
+			Value:      "first",
+			Err:        datastore.ErrNoSuchEntity,
+		}
+		return
+	}
+
+	m = ms[0]
+	return
+}
+
+// Keys runs the query and returns the keys of every matching T
+func (rq *RepoQuery[T]) Keys(ctx context.Context) ([]*datastore.Key, error) {
+	return Query(ctx, rq.q.KeysOnly(), nil)
+}
+
+// Count returns the number of matching Ts
+func (rq *RepoQuery[T]) Count(ctx context.Context) (n int, myerr error) {
+	myerr = retry(ctx, true, func() (err error) {
+		n, err = rq.q.Count(ctx)
+		return
+	})
+
+	return
+}
+
+// Datastore returns the underlying datastore query
+func (rq *RepoQuery[T]) Datastore() *datastore.Query {
+	return rq.q
+}
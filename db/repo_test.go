@@ -0,0 +1,121 @@
+package db
+
+import (
+	"testing"
+
+	"google.golang.org/appengine/datastore"
+)
+
+func TestRepo(t *testing.T) {
+	defer ResetDB()
+	ctx := GetCtx()
+
+	r := NewRepo[*Foo]()
+	if r.Kind() != "Foo" {
+		t.Fatalf("Repo has the wrong kind. Wanted: Foo; Got: %s", r.Kind())
+	}
+
+	if _, err := NewModel("Foo"); err != nil {
+		t.Fatalf("NewRepo did not register the kind. Error: %v", err)
+	}
+
+	f := r.New()
+	f.String = "repo"
+	f.Int = 42
+	if err := r.Put(ctx, f); err != nil {
+		t.Fatalf("Put threw an error. Error: %v", err)
+	}
+
+	got, err := r.Get(ctx, f.GetKey().IntID())
+	if err != nil {
+		t.Fatalf("Get threw an error. Error: %v", err)
+	}
+	if got.String != "repo" || got.Int != 42 {
+		t.Fatalf("Get returned the wrong Foo. Got: %+v", got)
+	}
+
+	if got, err = r.GetS(ctx, f.GetKey().Encode()); err != nil || got.Int != 42 {
+		t.Fatalf("GetS did not return the Foo. Error: %v", err)
+	}
+
+	if _, err = r.Get(ctx, 100); err == nil {
+		t.Fatal("Get did not throw an error when the object did not exist")
+	}
+
+	fs := []*Foo{{String: "a", Int: 1}, {String: "b", Int: 2}}
+	if err = r.PutMulti(ctx, fs); err != nil {
+		t.Fatalf("PutMulti threw an error. Error: %v", err)
+	}
+
+	all, err := r.GetMulti(ctx, []int64{fs[0].GetKey().IntID(), fs[1].GetKey().IntID()})
+	if err != nil {
+		t.Fatalf("GetMulti threw an error. Error: %v", err)
+	}
+	if len(all) != 2 || all[0].String != "a" || all[1].String != "b" {
+		t.Fatalf("GetMulti returned the wrong Foos. Got: %+v", all)
+	}
+
+	if err = r.Delete(ctx, f); err != nil {
+		t.Fatalf("Delete threw an error. Error: %v", err)
+	}
+	if _, err = r.GetKey(ctx, f.GetKey()); err == nil {
+		t.Fatal("Delete did not delete the Foo")
+	}
+}
+
+func TestRepoQuery(t *testing.T) {
+	defer ResetDB()
+	ctx := GetCtx()
+
+	r := NewRepo[*Foo]()
+	for _, i := range []int64{300, 100, 200} {
+		createFullFoo(ctx, t, "query", i)
+	}
+
+	// the Foo properties are not indexed, so only key and kind queries work
+	all, err := r.Query().Order("__key__").All(ctx)
+	if err != nil {
+		t.Fatalf("All threw an error. Error: %v", err)
+	}
+	if len(all) != 3 {
+		t.Fatalf("All returned the wrong number of Foos. Wanted: 3; Got: %d", len(all))
+	}
+	for _, f := range all {
+		if f.GetKey() == nil || f.String != "query" {
+			t.Fatalf("All did not load the Foos. Got: %+v", f)
+		}
+	}
+
+	first, err := r.Query().Order("__key__").First(ctx)
+	if err != nil {
+		t.Fatalf("First threw an error. Error: %v", err)
+	}
+	if !first.GetKey().Equal(all[0].GetKey()) {
+		t.Fatal("First did not return the first Foo")
+	}
+
+	keys, err := r.Query().Keys(ctx)
+	if err != nil || len(keys) != 3 {
+		t.Fatalf("Keys returned the wrong keys. Error: %v; Got: %v", err, keys)
+	}
+
+	n, err := r.Query().Count(ctx)
+	if err != nil || n != 3 {
+		t.Fatalf("Count returned the wrong count. Error: %v; Got: %d", err, n)
+	}
+
+	none := r.Query().Filter("__key__ =", datastore.NewKey(ctx, r.Kind(), "", 1, nil))
+	if _, err = none.First(ctx); err == nil {
+		t.Fatal("First did not throw an error when nothing matched")
+	}
+}
+
+func TestRepoPanic(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("NewRepo did not panic for a Model that is not a pointer to a struct")
+		}
+	}()
+
+	NewRepo[Model]()
+}
@@ -0,0 +1,228 @@
+package db
+
+import (
+	"context"
+	"log/slog"
+	"reflect"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+
+	"github.com/benjamw/golibs/random"
+)
+
+// datastore_v3 API error codes that are worth retrying
+const (
+	apiConcurrentTransaction = 2
+	apiInternalError         = 3
+	apiTimeout               = 5
+	apiBigtableError         = 7
+)
+
+// RetryPolicy describes how failed datastore calls get retried
+type RetryPolicy struct {
+	MaxAttempts    int           // total number of attempts, 1 or less disables retrying
+	InitialBackoff time.Duration // wait before the first retry
+	MaxBackoff     time.Duration // upper limit for the wait between retries
+	Multiplier     float64       // growth of the wait after each retry, less than 1 is treated as 2
+	Jitter         float64       // fraction (0-1) of each wait that is randomized
+
+	// Retryable reports whether err is transient, nil uses IsTransient
+	Retryable func(err error) bool
+
+	// RetryNonIdempotent allows retrying ambiguous failures (like timeouts) of calls
+	// that are not safe to repeat, such as puts with incomplete keys and transactions,
+	// which might have been applied even though they failed.
+	// When false, those calls are only retried on contention errors, which are never applied.
+	RetryNonIdempotent bool
+}
+
+// DefaultRetryPolicy is the policy used by db until SetRetryPolicy is called
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: 100 * time.Millisecond,
+	MaxBackoff:     2 * time.Second,
+	Multiplier:     2,
+	Jitter:         0.5,
+}
+
+// retryPolicy holds the policy set with SetRetryPolicy, nil for DefaultRetryPolicy
+var retryPolicy atomic.Pointer[RetryPolicy]
+
+// SetRetryPolicy sets the retry policy used for all datastore calls made by db
+// It is safe to call while datastore calls are running, they keep the policy they started with
+func SetRetryPolicy(p RetryPolicy) {
+	retryPolicy.Store(&p)
+}
+
+// getRetryPolicy returns the current retry policy
+func getRetryPolicy() RetryPolicy {
+	if p := retryPolicy.Load(); p != nil {
+		return *p
+	}
+
+	return DefaultRetryPolicy
+}
+
+// IsTransient reports whether err is a datastore error that might succeed when retried
+func IsTransient(err error) bool {
+	if me, ok := err.(appengine.MultiError); ok {
+		for _, e := range me {
+			if e != nil && IsTransient(e) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if isContention(err) || appengine.IsTimeoutError(err) {
+		return true
+	}
+
+	code := apiErrorCode(err)
+	return code == apiInternalError || code == apiTimeout || code == apiBigtableError
+}
+
+// isContention reports whether err is a contention error, which means nothing was written
+func isContention(err error) bool {
+	if me, ok := err.(appengine.MultiError); ok {
+		for _, e := range me {
+			if e != nil && !isContention(e) {
+				return false
+			}
+		}
+		return len(me) > 0
+	}
+
+	return err == datastore.ErrConcurrentTransaction || apiErrorCode(err) == apiConcurrentTransaction
+}
+
+// apiErrorCode returns the code of a datastore_v3 API error, or 0 if err is not one
+// The API error type lives in an internal appengine package, so its fields are read by reflection
+func apiErrorCode(err error) int32 {
+	v := reflect.ValueOf(err)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return 0
+	}
+	v = v.Elem()
+
+	service := v.FieldByName("Service")
+	code := v.FieldByName("Code")
+	if !service.IsValid() || service.Kind() != reflect.String || service.String() != "datastore_v3" {
+		return 0
+	}
+	if !code.IsValid() || code.Kind() != reflect.Int32 {
+		return 0
+	}
+
+	return int32(code.Int())
+}
+
+func (p RetryPolicy) retryable(err error, idempotent bool) bool {
+	if !idempotent && !p.RetryNonIdempotent {
+		return isContention(err)
+	}
+
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+
+	return IsTransient(err)
+}
+
+// backoff returns the wait after the given (1 based) failed attempt
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	mult := p.Multiplier
+	if mult < 1 {
+		mult = 2
+	}
+
+	d := float64(p.InitialBackoff)
+	for i := 1; i < attempt; i++ {
+		d *= mult
+		if p.MaxBackoff > 0 && d > float64(p.MaxBackoff) {
+			d = float64(p.MaxBackoff)
+			break
+		}
+	}
+
+	if p.Jitter > 0 {
+		j := p.Jitter
+		if j > 1 {
+			j = 1
+		}
+
+		// take a random amount of up to the jitter fraction off the wait
+		spread := int64(d * j)
+		d -= float64(random.Intn(0, spread))
+	}
+
+	return time.Duration(d)
+}
+
+// retry calls f until it succeeds or the retry policy gives up
+// idempotent should be false when calling f twice could apply a change twice
+func retry(ctx context.Context, idempotent bool, f func() error) error {
+	p := getRetryPolicy()
+
+	var errs []error
+	for attempt := 1; ; attempt++ {
+		err := f()
+		if err == nil {
+			return nil
+		}
+
+		errs = append(errs, err)
+		if attempt >= p.MaxAttempts || !p.retryable(err, idempotent) {
+			break
+		}
+
+		wait := p.backoff(attempt)
+		getLogger().LogAttrs(ctx, slog.LevelDebug, "retrying datastore call",
+			slog.Int("attempt", attempt), slog.Duration("wait", wait), slog.Any("error", err))
+
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			errs = append(errs, ctx.Err())
+			return &RetryError{Attempts: errs}
+		case <-t.C:
+		}
+	}
+
+	// don't hide errors that were never retried
+	if len(errs) == 1 {
+		return errs[0]
+	}
+
+	return &RetryError{Attempts: errs}
+}
+
+// RunInTransaction runs f in a transaction (see datastore.RunInTransaction),
+// retrying the whole transaction according to the retry policy
+// Transactions are not idempotent, so ambiguous failures are only retried if the policy allows it.
+// The changes captured by db functions called with tc are written in the transaction (see ChangeOptions).
+func RunInTransaction(ctx context.Context, f func(tc context.Context) error, opts *datastore.TransactionOptions) error {
+	return retry(ctx, false, func() error {
+		return runInTransaction(ctx, f, opts)
+	})
+}
+
+// txKey is the context key that marks the contexts of the transactions started by db
+type txKey struct{}
+
+// runInTransaction runs f in a transaction, marking its context so isTransaction can tell
+func runInTransaction(ctx context.Context, f func(tc context.Context) error, opts *datastore.TransactionOptions) error {
+	return datastore.RunInTransaction(ctx, func(tc context.Context) error {
+		return f(context.WithValue(tc, txKey{}, true))
+	}, opts)
+}
+
+// isTransaction reports whether ctx is the context of a transaction started by db
+func isTransaction(ctx context.Context) bool {
+	tx, _ := ctx.Value(txKey{}).(bool)
+	return tx
+}
@@ -0,0 +1,182 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+func setTestRetryPolicy() func() {
+	old := getRetryPolicy()
+	SetRetryPolicy(RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     5 * time.Millisecond,
+		Jitter:         0.5,
+	})
+
+	return func() {
+		SetRetryPolicy(old)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	defer setTestRetryPolicy()()
+	ctx := context.Background()
+
+	// succeed on the last attempt
+	n := 0
+	err := retry(ctx, true, func() error {
+		n++
+		if n < 3 {
+			return datastore.ErrConcurrentTransaction
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("retry threw an error when the last attempt succeeded. Error: %v", err)
+	}
+	if n != 3 {
+		t.Fatalf("retry made the wrong number of attempts. Wanted: 3; Got: %d", n)
+	}
+
+	// give up after MaxAttempts
+	n = 0
+	err = retry(ctx, true, func() error {
+		n++
+		return datastore.ErrConcurrentTransaction
+	})
+	if n != 3 {
+		t.Fatalf("retry made the wrong number of attempts. Wanted: 3; Got: %d", n)
+	}
+
+	var re *RetryError
+	if !errors.As(err, &re) {
+		t.Fatalf("retry did not throw a RetryError. Got: %T: %v", err, err)
+	}
+	if len(re.Attempts) != 3 {
+		t.Fatalf("RetryError has the wrong number of attempts. Wanted: 3; Got: %d", len(re.Attempts))
+	}
+	if !errors.Is(err, datastore.ErrConcurrentTransaction) {
+		t.Fatal("RetryError does not unwrap to the cause of the attempts")
+	}
+
+	// errors that aren't transient don't get retried or wrapped
+	n = 0
+	err = retry(ctx, true, func() error {
+		n++
+		return datastore.ErrNoSuchEntity
+	})
+	if n != 1 {
+		t.Fatalf("retry retried an error that is not transient. Attempts: %d", n)
+	}
+	if err != datastore.ErrNoSuchEntity {
+		t.Fatalf("retry wrapped an error that was never retried. Got: %T: %v", err, err)
+	}
+}
+
+func TestRetryNonIdempotent(t *testing.T) {
+	defer setTestRetryPolicy()()
+	ctx := context.Background()
+
+	timeout := errors.New("timeout")
+	SetRetryPolicy(RetryPolicy{
+		MaxAttempts: 3,
+		Retryable:   func(err error) bool { return true },
+	})
+
+	// ambiguous errors are not retried for calls that aren't idempotent
+	n := 0
+	retry(ctx, false, func() error {
+		n++
+		return timeout
+	})
+	if n != 1 {
+		t.Fatalf("retry retried an ambiguous error on a call that is not idempotent. Attempts: %d", n)
+	}
+
+	// but contention errors are
+	n = 0
+	retry(ctx, false, func() error {
+		n++
+		return datastore.ErrConcurrentTransaction
+	})
+	if n != 3 {
+		t.Fatalf("retry did not retry a contention error. Wanted: 3; Got: %d", n)
+	}
+
+	// unless the policy allows it
+	p := getRetryPolicy()
+	p.RetryNonIdempotent = true
+	SetRetryPolicy(p)
+	n = 0
+	retry(ctx, false, func() error {
+		n++
+		return timeout
+	})
+	if n != 3 {
+		t.Fatalf("retry did not retry an ambiguous error when allowed. Wanted: 3; Got: %d", n)
+	}
+}
+
+func TestRetryContext(t *testing.T) {
+	defer setTestRetryPolicy()()
+	p := getRetryPolicy()
+	p.InitialBackoff = time.Hour
+	SetRetryPolicy(p)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := retry(ctx, true, func() error {
+		return datastore.ErrConcurrentTransaction
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("retry did not stop when the context was cancelled. Got: %v", err)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	p := RetryPolicy{
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     time.Second,
+		Multiplier:     2,
+	}
+
+	want := []time.Duration{100, 200, 400, 800, 1000, 1000}
+	for i, w := range want {
+		if d := p.backoff(i + 1); d != w*time.Millisecond {
+			t.Fatalf("backoff returned the wrong wait for attempt %d. Wanted: %v; Got: %v", i+1, w*time.Millisecond, d)
+		}
+	}
+
+	p.Jitter = 0.5
+	for i := 0; i < 1000; i++ {
+		d := p.backoff(1)
+		if d < 50*time.Millisecond || 100*time.Millisecond < d {
+			t.Fatalf("backoff returned a jittered wait out of range: %v", d)
+		}
+	}
+}
+
+func TestIsTransient(t *testing.T) {
+	if !IsTransient(datastore.ErrConcurrentTransaction) {
+		t.Fatal("IsTransient did not report a concurrent transaction as transient")
+	}
+
+	if IsTransient(datastore.ErrNoSuchEntity) {
+		t.Fatal("IsTransient reported a missing entity as transient")
+	}
+
+	if !IsTransient(appengine.MultiError{nil, datastore.ErrConcurrentTransaction}) {
+		t.Fatal("IsTransient did not report a MultiError with a transient error as transient")
+	}
+
+	if IsTransient(appengine.MultiError{nil, datastore.ErrNoSuchEntity}) {
+		t.Fatal("IsTransient reported a MultiError without a transient error as transient")
+	}
+}
@@ -0,0 +1,193 @@
+package db
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"unicode"
+
+	"google.golang.org/appengine/datastore"
+
+	gstrings "github.com/benjamw/golibs/strings"
+)
+
+const (
+	// SearchIndexKind is the kind of the companion entities that hold the search tokens of a model
+	SearchIndexKind = "SearchIndex"
+
+	// DefaultSearchLimit is the maximum number of models returned by Search
+	DefaultSearchLimit = 100
+
+	// tagSearch marks a string field as searchable: `db:",search"`
+	tagSearch = "search"
+
+	// searchMinPrefix and searchMaxPrefix limit the lengths of the prefix tokens
+	searchMinPrefix = 2
+	searchMaxPrefix = 20
+)
+
+// searchIndex is the companion entity that holds the search tokens of a model
+// Its parent is the key of the model it indexes
+type searchIndex struct {
+	Kind   string
+	Tokens []string // every word and word prefix, for matching
+	Words  []string `datastore:",noindex"` // every full word, for ranking
+}
+
+type searchIndexer struct{}
+
+func init() {
+	indexers = append(indexers, searchIndexer{})
+}
+
+func (searchIndexer) tag() string {
+	return tagSearch
+}
+
+func (searchIndexer) kind() string {
+	return SearchIndexKind
+}
+
+func (searchIndexer) keys(ctx context.Context, k *datastore.Key, names []string) []*datastore.Key {
+	return []*datastore.Key{datastore.NewKey(ctx, SearchIndexKind, tagSearch, 0, k)}
+}
+
+func (si searchIndexer) entities(ctx context.Context, k *datastore.Key, names []string, pl []datastore.Property) ([]*datastore.Key, []interface{}, error) {
+	idx := &searchIndex{
+		Kind: k.Kind(),
+	}
+
+	seen := make(map[string]bool)
+	for _, p := range pl {
+		s, ok := p.Value.(string)
+		if !ok || !hasProperty(names, p.Name) {
+			continue
+		}
+
+		for _, w := range searchWords(s) {
+			idx.Words = append(idx.Words, w)
+
+			for _, t := range searchPrefixes(w) {
+				if !seen[t] {
+					seen[t] = true
+					idx.Tokens = append(idx.Tokens, t)
+				}
+			}
+		}
+	}
+
+	if len(idx.Tokens) == 0 {
+		return nil, nil, nil
+	}
+
+	return si.keys(ctx, k, names), []interface{}{idx}, nil
+}
+
+// Search returns the models of the given kind whose searchable properties
+// contain every word of the query (or a word starting with it), best matches first
+// It returns at most DefaultSearchLimit models, of all the matches, and skips the ones that are missing
+//
+// The kind must be registered with RegisterKind.
+func Search(ctx context.Context, kind string, query string) (models []Model, myerr error) {
+	words := searchWords(query)
+	if len(words) == 0 {
+		return
+	}
+
+	q := datastore.NewQuery(SearchIndexKind).Filter("Kind =", kind)
+	for _, w := range words {
+		if len(w) > searchMaxPrefix {
+			w = w[:searchMaxPrefix]
+		}
+		q = q.Filter("Tokens =", w)
+	}
+
+	var indexes []searchIndex
+	keys, myerr := Query(ctx, q, &indexes)
+	if myerr != nil {
+		return
+	}
+
+	type result struct {
+		key   *datastore.Key
+		score int
+		words int
+	}
+
+	results := make([]result, len(keys))
+	for i, k := range keys {
+		results[i] = result{
+			key:   k.Parent(),
+			score: searchScore(words, indexes[i].Words),
+			words: len(indexes[i].Words),
+		}
+	}
+
+	// best score first, then the shortest text
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].score != results[j].score {
+			return results[i].score > results[j].score
+		}
+		return results[i].words < results[j].words
+	})
+
+	if len(results) > DefaultSearchLimit {
+		results = results[:DefaultSearchLimit]
+	}
+
+	modelKeys := make([]*datastore.Key, len(results))
+	for i, r := range results {
+		modelKeys[i] = r.key
+	}
+
+	models, myerr = loadExisting(ctx, kind, modelKeys)
+	return
+}
+
+// searchWords normalizes s and splits it into lowercase ASCII words
+func searchWords(s string) (words []string) {
+	s = strings.ToLower(gstrings.ToASCII(s))
+
+	seen := make(map[string]bool)
+	for _, w := range strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if !seen[w] {
+			seen[w] = true
+			words = append(words, w)
+		}
+	}
+
+	return
+}
+
+// searchPrefixes returns the prefix tokens of w, including w itself if it isn't too long
+func searchPrefixes(w string) (tokens []string) {
+	for i := searchMinPrefix; i <= len(w) && i <= searchMaxPrefix; i++ {
+		tokens = append(tokens, w[:i])
+	}
+
+	// short words are still searchable
+	if len(w) < searchMinPrefix {
+		tokens = append(tokens, w)
+	}
+
+	return
+}
+
+// searchScore ranks a match by giving two points for every query word found whole and
+// one point for every query word only found as a prefix
+func searchScore(query []string, words []string) (score int) {
+	for _, q := range query {
+		points := 1
+		for _, w := range words {
+			if w == q {
+				points = 2
+				break
+			}
+		}
+		score += points
+	}
+
+	return
+}
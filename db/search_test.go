@@ -0,0 +1,216 @@
+package db
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"google.golang.org/appengine/datastore"
+)
+
+func TestSearchWords(t *testing.T) {
+	got := searchWords("  Hello, WORLD! hello-again_x2 ")
+	want := []string{"hello", "world", "again", "x2"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("searchWords returned the wrong words. Wanted: %v; Got: %v", want, got)
+	}
+
+	got = searchPrefixes("hello")
+	want = []string{"he", "hel", "hell", "hello"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("searchPrefixes returned the wrong tokens. Wanted: %v; Got: %v", want, got)
+	}
+
+	if got = searchPrefixes("a"); !reflect.DeepEqual(got, []string{"a"}) {
+		t.Fatalf("searchPrefixes did not keep a short word. Got: %v", got)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	defer ResetDB()
+	ctx := GetCtx()
+
+	RegisterKind(new(Person).EntityType(), func() Model { return new(Person) })
+
+	john := createPerson(ctx, t, "John Smith", "Likes apples")
+	johnny := createPerson(ctx, t, "Johnny Appleseed", "Plants apple trees")
+	createPerson(ctx, t, "Jane Doe", "Nothing to see here")
+
+	models, err := Search(ctx, new(Person).EntityType(), "john")
+	if err != nil {
+		t.Fatalf("Search threw an error. Error: %v", err)
+	}
+	if len(models) != 2 {
+		t.Fatalf("Search returned the wrong number of models. Wanted: 2; Got: %d", len(models))
+	}
+
+	// the whole word match ranks first
+	if !models[0].GetKey().Equal(john.GetKey()) || !models[1].GetKey().Equal(johnny.GetKey()) {
+		t.Fatalf("Search returned the models in the wrong order. Got: %v, %v", models[0].GetKey(), models[1].GetKey())
+	}
+	if models[0].(*Person).Name != "John Smith" {
+		t.Fatalf("Search did not load the model. Got: %+v", models[0])
+	}
+
+	// every word has to match, across properties
+	models, err = Search(ctx, new(Person).EntityType(), "JOHN apple")
+	if err != nil {
+		t.Fatalf("Search threw an error. Error: %v", err)
+	}
+	if len(models) != 2 {
+		t.Fatalf("Search returned the wrong number of models. Wanted: 2; Got: %d", len(models))
+	}
+
+	models, err = Search(ctx, new(Person).EntityType(), "john trees")
+	if err != nil {
+		t.Fatalf("Search threw an error. Error: %v", err)
+	}
+	if len(models) != 1 || !models[0].GetKey().Equal(johnny.GetKey()) {
+		t.Fatalf("Search returned the wrong models. Got: %v", models)
+	}
+
+	// deleting the model removes it from the index
+	if err = Delete(ctx, &john); err != nil {
+		t.Fatalf("Delete threw an error. Error: %v", err)
+	}
+
+	var idx searchIndex
+	err = datastore.Get(ctx, searchIndexer{}.keys(ctx, john.GetKey(), nil)[0], &idx)
+	if err != datastore.ErrNoSuchEntity {
+		t.Fatalf("Delete did not remove the search index. Error: %v", err)
+	}
+}
+
+func TestSearchUnregistered(t *testing.T) {
+	defer ResetDB()
+	ctx := GetCtx()
+
+	createPerson(ctx, t, "Some One", "")
+
+	_, err := Search(ctx, "Unregistered", "some")
+	if err != nil {
+		t.Fatalf("Search threw an error when nothing matched. Error: %v", err)
+	}
+
+	kindsMu.Lock()
+	delete(kinds, new(Person).EntityType())
+	kindsMu.Unlock()
+
+	_, err = Search(ctx, new(Person).EntityType(), "some")
+	if _, ok := err.(*UnregisteredKindError); !ok {
+		t.Fatalf("Search did not throw an UnregisteredKindError. Got: %T: %v", err, err)
+	}
+}
+
+func TestSearchMissing(t *testing.T) {
+	defer ResetDB()
+	ctx := GetCtx()
+
+	RegisterKind(new(Person).EntityType(), func() Model { return new(Person) })
+
+	john := createPerson(ctx, t, "John Smith", "")
+	johnny := createPerson(ctx, t, "Johnny Appleseed", "")
+
+	// a model deleted behind db's back leaves its search index behind
+	if err := datastore.Delete(ctx, john.GetKey()); err != nil {
+		t.Fatalf("datastore.Delete threw an error. Error: %v", err)
+	}
+
+	models, err := Search(ctx, new(Person).EntityType(), "john")
+	if err != nil {
+		t.Fatalf("Search threw an error for a missing model. Error: %v", err)
+	}
+	if len(models) != 1 || !models[0].GetKey().Equal(johnny.GetKey()) {
+		t.Fatalf("Search did not skip the missing model. Got: %v", models)
+	}
+
+	// DeleteMultiK removes the search index too
+	if err = DeleteMultiK(ctx, []*datastore.Key{johnny.GetKey()}); err != nil {
+		t.Fatalf("DeleteMultiK threw an error. Error: %v", err)
+	}
+
+	var idx searchIndex
+	err = datastore.Get(ctx, searchIndexer{}.keys(ctx, johnny.GetKey(), nil)[0], &idx)
+	if err != datastore.ErrNoSuchEntity {
+		t.Fatalf("DeleteMultiK did not remove the search index. Error: %v", err)
+	}
+}
+
+func TestSearchLimit(t *testing.T) {
+	defer ResetDB()
+	ctx := GetCtx()
+
+	RegisterKind(new(Person).EntityType(), func() Model { return new(Person) })
+
+	models := make([]Model, DefaultSearchLimit+10)
+	for i := range models {
+		models[i] = &Person{Name: "Johnny", Bio: "one of many"}
+	}
+
+	// the best match comes last
+	best := &Person{Name: "John"}
+	models = append(models, best)
+
+	if err := SaveMulti(ctx, models); err != nil {
+		t.Fatalf("SaveMulti threw an error. Error: %v", err)
+	}
+
+	// perform a Get to force the index to be applied so it's available in queries
+	var idx searchIndex
+	if err := datastore.Get(ctx, searchIndexer{}.keys(ctx, best.GetKey(), nil)[0], &idx); err != nil {
+		t.Fatalf("Could not get the search index. Error: %v", err)
+	}
+
+	found, err := Search(ctx, new(Person).EntityType(), "john")
+	if err != nil {
+		t.Fatalf("Search threw an error. Error: %v", err)
+	}
+	if len(found) != DefaultSearchLimit {
+		t.Fatalf("Search returned the wrong number of models. Wanted: %d; Got: %d", DefaultSearchLimit, len(found))
+	}
+	if !found[0].GetKey().Equal(best.GetKey()) {
+		t.Fatalf("Search did not rank all the matches before limiting them. Got: %v", found[0].GetKey())
+	}
+}
+
+// HELPER FUNCTIONS
+
+func createPerson(ctx context.Context, t *testing.T, name string, bio string) Person {
+	file, line, funct := GetCaller()
+
+	p := Person{
+		Name: name,
+		Bio:  bio,
+	}
+	if err := Save(ctx, &p); err != nil {
+		t.Fatalf("Could not save the test Person. Func: %s; File: %s; Line: %d; Error: %v", funct, file, line, err)
+	}
+
+	// perform a Get to force the index to be applied so it's available in queries
+	var idx searchIndex
+	if err := datastore.Get(ctx, searchIndexer{}.keys(ctx, p.GetKey(), nil)[0], &idx); err != nil {
+		t.Fatalf("Could not get the test Person's search index. Func: %s; File: %s; Line: %d; Error: %v", funct, file, line, err)
+	}
+
+	return p
+}
+
+// HELPER STRUCTS
+
+type Person struct {
+	base
+	Name string `db:",search"`
+	Bio  string `datastore:",noindex" db:",search"`
+}
+
+func (m *Person) EntityType() string {
+	return "Person"
+}
+
+func (m *Person) PreSave(c context.Context) error {
+	if m.GetKey() == nil {
+		m.SetIsNew(true)
+		m.SetKey(datastore.NewIncompleteKey(c, m.EntityType(), nil))
+	}
+	return nil
+}
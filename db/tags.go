@@ -0,0 +1,85 @@
+package db
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// taggedCache caches the results of taggedFields per type and option
+var taggedCache sync.Map // map[taggedKey][]string
+
+type taggedKey struct {
+	t   reflect.Type
+	opt string
+}
+
+// taggedFields returns the datastore property names of the fields of model m
+// that have the given option in their db struct tag, e.g.: `db:",encrypted"`
+func taggedFields(m interface{}, opt string) []string {
+	t := reflect.TypeOf(m)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil
+	}
+
+	k := taggedKey{t, opt}
+	if names, ok := taggedCache.Load(k); ok {
+		return names.([]string)
+	}
+
+	names := findTagged(t, opt, "")
+	taggedCache.Store(k, names)
+
+	return names
+}
+
+func findTagged(t reflect.Type, opt string, prefix string) (names []string) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" && !f.Anonymous {
+			continue
+		}
+
+		name := strings.Split(f.Tag.Get("datastore"), ",")[0]
+		if name == "-" {
+			continue
+		}
+
+		// the fields of embedded structs are promoted, just like the datastore does it
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			sub := prefix
+			if name != "" {
+				sub = prefix + name + "."
+			}
+			names = append(names, findTagged(f.Type, opt, sub)...)
+			continue
+		}
+
+		if name == "" {
+			name = f.Name
+		}
+
+		for _, o := range strings.Split(f.Tag.Get("db"), ",")[1:] {
+			if o == opt {
+				names = append(names, prefix+name)
+				break
+			}
+		}
+	}
+
+	return
+}
+
+// hasProperty reports whether name is in names
+func hasProperty(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,233 @@
+package hooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"runtime/debug"
+	"sync"
+
+	"google.golang.org/appengine/log"
+)
+
+const (
+	// DefaultPoolWorkers is the number of workers of a Pool
+	DefaultPoolWorkers = 4
+
+	// DefaultPoolQueueSize is the number of events each worker of a Pool can have waiting
+	DefaultPoolQueueSize = 100
+)
+
+var (
+	// ErrQueueFull gets returned by Pool.Go when the queue is full and the policy is FailWhenFull
+	ErrQueueFull = errors.New("hook queue is full")
+
+	// ErrPoolClosed gets returned by Pool.Go after Shutdown
+	ErrPoolClosed = errors.New("hook pool is shut down")
+)
+
+// OverflowPolicy decides what Pool.Go does when the queue is full
+type OverflowPolicy int
+
+const (
+	// BlockWhenFull waits for room in the queue, or for the context to be done (the default)
+	BlockWhenFull OverflowPolicy = iota
+
+	// DropWhenFull drops the event without an error
+	DropWhenFull
+
+	// FailWhenFull returns ErrQueueFull
+	FailWhenFull
+)
+
+// PoolOptions configures a Pool
+type PoolOptions struct {
+	Workers   int            // number of workers, defaults to DefaultPoolWorkers
+	QueueSize int            // events each worker can have waiting, defaults to DefaultPoolQueueSize
+	Overflow  OverflowPolicy // what to do when the queue is full
+	Dispatch  *DispatchOptions
+
+	// OnResult gets the result of every dispatch, the listener errors get logged if it's nil
+	OnResult func(context.Context, *Result)
+
+	// OnError gets the errors of the pool itself, like a *PanicError of a dispatch that panicked
+	// (when the hook got unregistered after the event was queued), they get logged if it's nil
+	OnError func(ctx context.Context, hook string, err error)
+}
+
+// Pool does hooks asynchronously with a bounded number of workers
+//
+// All the events of a hook go to the same worker, so every listener gets them in the order they were queued,
+// and within an event the listeners are called in priority order like Do.
+type Pool struct {
+	r      *Registry
+	opts   PoolOptions
+	queues []chan event
+	wg     sync.WaitGroup // the workers
+
+	// closing gets closed by Shutdown, so the Go calls waiting for room in a queue give up,
+	// and the queues get closed once every Go call that got past the closed check returned
+	closing chan struct{}
+	sending sync.WaitGroup
+
+	mu     sync.Mutex // guards closed, and is never held while waiting
+	closed bool
+}
+
+// event is a queued hook
+type event struct {
+	hook string
+	ctx  context.Context
+	p    []interface{}
+}
+
+// NewPool starts a Pool for the hooks of the given Registry
+func NewPool(r *Registry, opts PoolOptions) *Pool {
+	if opts.Workers <= 0 {
+		opts.Workers = DefaultPoolWorkers
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = DefaultPoolQueueSize
+	}
+
+	p := &Pool{
+		r:       r,
+		opts:    opts,
+		queues:  make([]chan event, opts.Workers),
+		closing: make(chan struct{}),
+	}
+
+	for i := range p.queues {
+		p.queues[i] = make(chan event, opts.QueueSize)
+
+		p.wg.Add(1)
+		go p.work(p.queues[i])
+	}
+
+	return p
+}
+
+// Go queues the given hook with the given parameters
+//
+// The listeners get ctx without its cancellation, as they usually run after the caller is done.
+func (p *Pool) Go(hook string, ctx context.Context, params ...interface{}) error {
+	if !p.r.IsRegistered(hook) {
+		panic(HookError{fmt.Sprintf("%s hook not found in registry", hook)})
+	}
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return ErrPoolClosed
+	}
+	p.sending.Add(1)
+	p.mu.Unlock()
+
+	defer p.sending.Done()
+
+	e := event{
+		hook: hook,
+		ctx:  context.WithoutCancel(ctx),
+		p:    params,
+	}
+
+	q := p.queues[p.worker(hook)]
+	switch p.opts.Overflow {
+	case DropWhenFull, FailWhenFull:
+		select {
+		case q <- e:
+		default:
+			if p.opts.Overflow == FailWhenFull {
+				return ErrQueueFull
+			}
+		}
+	default:
+		select {
+		case q <- e:
+		case <-p.closing:
+			return ErrPoolClosed
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
+
+// Shutdown stops accepting events and waits until the queued ones are done, or until ctx is done
+// Go calls waiting for room in a queue return ErrPoolClosed.
+func (p *Pool) Shutdown(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	first := !p.closed
+	p.closed = true
+	p.mu.Unlock()
+
+	if first {
+		close(p.closing)
+
+		go func() {
+			p.sending.Wait()
+			for _, q := range p.queues {
+				close(q)
+			}
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// worker returns the index of the worker of the given hook
+func (p *Pool) worker(hook string) int {
+	h := fnv.New32a()
+	h.Write([]byte(hook))
+
+	return int(h.Sum32() % uint32(len(p.queues)))
+}
+
+func (p *Pool) work(q chan event) {
+	defer p.wg.Done()
+
+	for e := range q {
+		p.do(e)
+	}
+}
+
+// do dispatches the event, a panic gets passed to OnError so it doesn't stop the worker
+func (p *Pool) do(e event) {
+	defer func() {
+		if v := recover(); v != nil {
+			err := &PanicError{Value: v, Stack: debug.Stack()}
+			if p.opts.OnError != nil {
+				p.opts.OnError(e.ctx, e.hook, err)
+				return
+			}
+
+			log.Errorf(e.ctx, "a %s hook panicked in a pool: %v", e.hook, err)
+		}
+	}()
+
+	res := p.r.Dispatch(e.hook, e.ctx, p.opts.Dispatch, e.p...)
+
+	if p.opts.OnResult != nil {
+		p.opts.OnResult(e.ctx, res)
+		return
+	}
+
+	logErrors(e.ctx, res)
+}
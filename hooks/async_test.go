@@ -0,0 +1,203 @@
+package hooks
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPool(t *testing.T) {
+	ctx := GetCtx()
+	reset()
+	defer reset()
+
+	l := "Pool"
+
+	var mu sync.Mutex
+	var got []string
+	Register(l, &TestListener{})
+	Listen(l, &TestListener{func(ctx context.Context, s string) (bool, error) {
+		time.Sleep(time.Millisecond)
+
+		mu.Lock()
+		defer mu.Unlock()
+		got = append(got, s)
+		return true, nil
+	}}, 1)
+
+	var results int
+	p := NewPool(DefaultRegistry, PoolOptions{
+		Workers: 3,
+		OnResult: func(ctx context.Context, res *Result) {
+			mu.Lock()
+			defer mu.Unlock()
+			results++
+		},
+	})
+
+	want := []string{"a", "b", "c", "d", "e"}
+	for _, s := range want {
+		if err := p.Go(l, ctx, s); err != nil {
+			t.Fatalf("TestPool: Go threw an error. Error: %v", err)
+		}
+	}
+
+	if err := p.Shutdown(context.Background()); err != nil {
+		t.Fatalf("TestPool: Shutdown threw an error. Error: %v", err)
+	}
+
+	// every queued event was done, in order
+	if len(got) != len(want) || results != len(want) {
+		t.Fatalf("TestPool: Shutdown did not drain the queue. Got: %v, %d results", got, results)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("TestPool: the events were done out of order. Wanted: %v; Got: %v", want, got)
+		}
+	}
+
+	if err := p.Go(l, ctx, "late"); !errors.Is(err, ErrPoolClosed) {
+		t.Fatalf("TestPool: Go did not throw ErrPoolClosed after Shutdown. Got: %v", err)
+	}
+}
+
+func TestPoolOverflow(t *testing.T) {
+	ctx := GetCtx()
+	reset()
+	defer reset()
+
+	l := "PoolOverflow"
+
+	release := make(chan struct{})
+	Register(l, &TestListener{})
+	Listen(l, &TestListener{func(ctx context.Context, s string) (bool, error) {
+		<-release
+		return true, nil
+	}}, 1)
+
+	p := NewPool(DefaultRegistry, PoolOptions{Workers: 1, QueueSize: 1, Overflow: FailWhenFull})
+
+	// the first one is taken by the worker, the second one waits, the third one doesn't fit
+	var err error
+	for i := 0; i < 10 && err == nil; i++ {
+		err = p.Go(l, ctx, "test")
+	}
+	if !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("TestPoolOverflow: Go did not throw ErrQueueFull. Got: %v", err)
+	}
+
+	// shutting down times out while the listener hangs
+	short, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err = p.Shutdown(short); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("TestPoolOverflow: Shutdown did not time out. Got: %v", err)
+	}
+
+	close(release)
+	if err = p.Shutdown(context.Background()); err != nil {
+		t.Fatalf("TestPoolOverflow: Shutdown threw an error. Error: %v", err)
+	}
+}
+
+func TestPoolShutdownBlocked(t *testing.T) {
+	ctx := GetCtx()
+	reset()
+	defer reset()
+
+	l := "PoolShutdownBlocked"
+
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	Register(l, &TestListener{})
+	Listen(l, &TestListener{func(ctx context.Context, s string) (bool, error) {
+		started <- struct{}{}
+		<-release
+		return true, nil
+	}}, 1)
+
+	p := NewPool(DefaultRegistry, PoolOptions{Workers: 1, QueueSize: 1})
+
+	// the first one is taken by the worker, the second one waits in the queue
+	if err := p.Go(l, ctx, "first"); err != nil {
+		t.Fatalf("TestPoolShutdownBlocked: Go threw an error. Error: %v", err)
+	}
+	<-started
+	if err := p.Go(l, ctx, "second"); err != nil {
+		t.Fatalf("TestPoolShutdownBlocked: Go threw an error. Error: %v", err)
+	}
+
+	// and the third one waits for room
+	blocked := make(chan error)
+	go func() {
+		blocked <- p.Go(l, context.Background(), "third")
+	}()
+
+	short, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := p.Shutdown(short); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("TestPoolShutdownBlocked: Shutdown did not honour its deadline. Got: %v", err)
+	}
+
+	if err := <-blocked; !errors.Is(err, ErrPoolClosed) {
+		t.Fatalf("TestPoolShutdownBlocked: the waiting Go did not throw ErrPoolClosed. Got: %v", err)
+	}
+
+	// a done context doesn't wait at all
+	if err := p.Shutdown(short); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("TestPoolShutdownBlocked: Shutdown did not check its context first. Got: %v", err)
+	}
+
+	close(release)
+	if err := p.Shutdown(context.Background()); err != nil {
+		t.Fatalf("TestPoolShutdownBlocked: Shutdown threw an error. Error: %v", err)
+	}
+}
+
+func TestPoolPanic(t *testing.T) {
+	ctx := GetCtx()
+	reset()
+	defer reset()
+
+	hang := "PoolPanicHang"
+	gone := "PoolPanicGone"
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	Register(hang, &TestListener{})
+	Listen(hang, &TestListener{func(ctx context.Context, s string) (bool, error) {
+		close(started)
+		<-release
+		return true, nil
+	}}, 1)
+	Register(gone, &TestListener{})
+
+	var mu sync.Mutex
+	var errs []error
+	p := NewPool(DefaultRegistry, PoolOptions{
+		Workers: 1,
+		OnError: func(ctx context.Context, hook string, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			errs = append(errs, err)
+		},
+	})
+
+	p.Go(hang, ctx, "hang")
+	<-started
+
+	// the hook is gone by the time its event is dispatched
+	p.Go(gone, ctx, "gone")
+	Unregister(gone)
+	close(release)
+
+	if err := p.Shutdown(context.Background()); err != nil {
+		t.Fatalf("TestPoolPanic: Shutdown threw an error. Error: %v", err)
+	}
+
+	var pe *PanicError
+	if len(errs) != 1 || !errors.As(errs[0], &pe) {
+		t.Fatalf("TestPoolPanic: did not pass the panic to OnError. Got: %v", errs)
+	}
+}
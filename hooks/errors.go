@@ -1,7 +1,9 @@
 package hooks
 
 import (
+	"fmt"
 	"net/http"
+	"time"
 )
 
 type HookError struct {
@@ -15,3 +17,65 @@ func (e *HookError) Error() string {
 func (e *HookError) Code() int {
 	return http.StatusInternalServerError // 500
 }
+
+// ListenerError wraps the error a listener returned
+type ListenerError struct {
+	Hook     string // the name of the hook
+	Priority int    // the priority the listener listens on
+	Err      error  // the original error
+}
+
+func (e *ListenerError) Error() string {
+	return fmt.Sprintf("a %s hook listener (priority %d) threw an error: %v", e.Hook, e.Priority, e.Err)
+}
+
+// Unwrap returns the original error
+func (e *ListenerError) Unwrap() error {
+	return e.Err
+}
+
+func (e *ListenerError) Code() int {
+	return http.StatusInternalServerError // 500
+}
+
+// PanicError is the error of a listener that panicked
+type PanicError struct {
+	Value interface{} // the value it panicked with
+	Stack []byte      // the stack trace of the panic
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("listener panicked: %v", e.Value)
+}
+
+// Unwrap returns the value it panicked with, if that is an error
+func (e *PanicError) Unwrap() error {
+	if err, ok := e.Value.(error); ok {
+		return err
+	}
+
+	return nil
+}
+
+func (e *PanicError) Code() int {
+	return http.StatusInternalServerError // 500
+}
+
+// TimeoutError is the error of a listener that did not return before its context was done
+type TimeoutError struct {
+	Timeout time.Duration // the listener timeout, 0 if it was the hook timeout or the context of the caller
+	Err     error         // the error of the context
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("listener did not return in time: %v", e.Err)
+}
+
+// Unwrap returns the error of the context
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+func (e *TimeoutError) Code() int {
+	return http.StatusGatewayTimeout // 504
+}
@@ -0,0 +1,116 @@
+package hooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Filter is a hook that passes a value through its listeners in priority order,
+// each one gets the value returned by the one before it
+type Filter[T any] struct {
+	r    *Registry
+	name string
+}
+
+// NewFilter registers a filter with the given name in the DefaultRegistry
+func NewFilter[T any](name string) *Filter[T] {
+	return NewFilterIn[T](DefaultRegistry, name)
+}
+
+// NewFilterIn registers a filter with the given name in the given Registry
+func NewFilterIn[T any](r *Registry, name string) *Filter[T] {
+	r.Register(name, &filterDoer[T]{})
+
+	return &Filter[T]{r: r, name: name}
+}
+
+// Name returns the name of the filter in its Registry
+func (f *Filter[T]) Name() string {
+	return f.name
+}
+
+// Listen for the filter with the given func on the given priority
+//
+// If fn returns ErrHalt, its value is the final value and the listeners after it are not called.
+// If it returns any other error, its value is dropped and the next listener gets the value it got.
+func (f *Filter[T]) Listen(fn func(context.Context, T) (T, error), priority int) *Subscription {
+	return f.r.Listen(f.name, &filterDoer[T]{fn}, priority)
+}
+
+// Configure sets the options the filter is applied with when no options are given
+func (f *Filter[T]) Configure(opts DispatchOptions) {
+	f.r.Configure(f.name, opts)
+}
+
+// Apply passes v through the listeners and returns the final value
+// and the errors of the listeners (joined with errors.Join)
+func (f *Filter[T]) Apply(ctx context.Context, v T) (T, error) {
+	v, res := f.ApplyWith(ctx, v, nil)
+
+	return v, res.Err()
+}
+
+// ApplyWith passes v through the listeners with the given options
+// and returns the final value and what happened with every listener that was called
+func (f *Filter[T]) ApplyWith(ctx context.Context, v T, opts *DispatchOptions) (T, *Result) {
+	fv := &filterValue[T]{v: v}
+	res := f.r.Dispatch(f.name, ctx, opts, fv)
+
+	return fv.get(), res
+}
+
+// filterValue holds the value while it passes through the listeners
+// A listener that timed out may still return, so the value is guarded.
+type filterValue[T any] struct {
+	mu sync.Mutex
+	v  T
+}
+
+func (fv *filterValue[T]) get() T {
+	fv.mu.Lock()
+	defer fv.mu.Unlock()
+
+	return fv.v
+}
+
+// set replaces the value, unless the context of the listener got done while it ran, as it timed out
+func (fv *filterValue[T]) set(ctx context.Context, wasDone bool, v T) {
+	fv.mu.Lock()
+	defer fv.mu.Unlock()
+
+	if wasDone || ctx.Err() == nil {
+		fv.v = v
+	}
+}
+
+// filterDoer is the Doer of a Filter[T]
+type filterDoer[T any] struct {
+	fn func(context.Context, T) (T, error)
+}
+
+func (d *filterDoer[T]) Do(ctx context.Context, p ...interface{}) (bool, error) {
+	if len(p) != 1 {
+		panic(HookError{fmt.Sprintf("filter called with %d parameters", len(p))})
+	}
+
+	fv, ok := p[0].(*filterValue[T])
+	if !ok {
+		panic(HookError{fmt.Sprintf("filter called with a %T", p[0])})
+	}
+
+	wasDone := ctx.Err() != nil
+	v, err := d.fn(ctx, fv.get())
+	if err != nil {
+		if errors.Is(err, ErrHalt) {
+			fv.set(ctx, wasDone, v)
+			return false, nil
+		}
+
+		return true, err
+	}
+
+	fv.set(ctx, wasDone, v)
+	return true, nil
+}
@@ -0,0 +1,40 @@
+package hooks
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestFilter(t *testing.T) {
+	ctx := GetCtx()
+	reset()
+	defer reset()
+
+	f := NewFilter[string]("Filter")
+
+	errBad := errors.New("bad")
+
+	// Note priority order here...
+	f.Listen(func(ctx context.Context, s string) (string, error) { return s + "!", nil }, 2)
+	f.Listen(func(ctx context.Context, s string) (string, error) { return strings.ToUpper(s), nil }, 1)
+	f.Listen(func(ctx context.Context, s string) (string, error) { return "dropped", errBad }, 3)
+	f.Listen(func(ctx context.Context, s string) (string, error) { return s + "?", nil }, 4)
+
+	v, err := f.Apply(ctx, "hello")
+	if v != "HELLO!?" {
+		t.Fatalf("TestFilter: returned the wrong value. Wanted: HELLO!?; Got: %s", v)
+	}
+	if !errors.Is(err, errBad) {
+		t.Fatalf("TestFilter: did not return the listener error. Got: %v", err)
+	}
+
+	// halting keeps the value of the halting listener
+	f.Listen(func(ctx context.Context, s string) (string, error) { return "halted", ErrHalt }, 0)
+
+	v, res := f.ApplyWith(ctx, "hello", nil)
+	if v != "halted" || !res.Halted || len(res.Listeners) != 1 {
+		t.Fatalf("TestFilter: did not halt. Got: %s, %+v", v, res)
+	}
+}
@@ -2,23 +2,20 @@ package hooks
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"reflect"
+	"runtime/debug"
 	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"google.golang.org/appengine/log"
 )
 
-var (
-	// registry holds the list of registered hooks and listener type for that hook
-	registry map[string]string
-
-	// container holds the list of registered listeners and their priorities for each hook
-	container map[string]map[int][]Doer
-
-	// priorities holds a cached list of sorted priorities for each hook
-	priorities map[string][]int
-)
+// DefaultRegistry is the Registry used by the package level functions
+var DefaultRegistry = NewRegistry()
 
 type Doer interface {
 	// Do should check the parameters and make sure they comply with
@@ -32,73 +29,472 @@ type Doer interface {
 	Do(context.Context, ...interface{}) (bool, error)
 }
 
-func init() {
-	reset()
+// Registry holds a set of hooks and their listeners, independent of any other Registry
+// It is safe for concurrent use: Register and Listen replace an immutable state,
+// so Do never waits on them and runs the listeners as they were when it was called
+type Registry struct {
+	mu    sync.Mutex // serializes the writers
+	state atomic.Pointer[state]
+}
+
+// state is an immutable snapshot of the hooks of a Registry
+type state struct {
+	// registry holds the list of registered hooks and listener type for that hook
+	registry map[string]string
+
+	// container holds the list of registered listeners and their priorities for each hook
+	container map[string]map[int][]*listener
+
+	// priorities holds a cached list of sorted priorities for each hook
+	priorities map[string][]int
+
+	// options holds the DispatchOptions set with Configure for each hook
+	options map[string]DispatchOptions
+
+	// patterns holds a cached sorted list of the hook patterns that have listeners
+	patterns []string
+
+	// middleware holds the middleware of every hook, and hookMiddleware the middleware of each hook or pattern
+	middleware     []Middleware
+	hookMiddleware map[string][]Middleware
+}
+
+func newState() *state {
+	return &state{
+		registry:   make(map[string]string, 0),
+		container:  make(map[string]map[int][]*listener, 0),
+		priorities: make(map[string][]int, 0),
+		options:    make(map[string]DispatchOptions, 0),
+
+		hookMiddleware: make(map[string][]Middleware, 0),
+	}
+}
+
+// clone returns a copy of s that can be changed, the inner maps and slices are still shared
+func (s *state) clone() *state {
+	c := newState()
+
+	for hook, t := range s.registry {
+		c.registry[hook] = t
+	}
+	for hook, ps := range s.container {
+		c.container[hook] = ps
+	}
+	for hook, p := range s.priorities {
+		c.priorities[hook] = p
+	}
+	for hook, o := range s.options {
+		c.options[hook] = o
+	}
+	c.patterns = s.patterns
+	c.middleware = s.middleware
+	for hook, mw := range s.hookMiddleware {
+		c.hookMiddleware[hook] = mw
+	}
+
+	return c
+}
+
+// listener wraps a Doer so every call to Listen can be told apart, even with the same Doer
+type listener struct {
+	doer Doer
+}
+
+// Subscription is a listener added with Listen
+type Subscription struct {
+	r        *Registry
+	hook     string
+	priority int
+	l        *listener
+}
+
+// Unlisten removes the listener, it does nothing if it was already removed
+func (sub *Subscription) Unlisten() {
+	sub.r.update(func(s *state) {
+		ls := s.container[sub.hook][sub.priority]
+		for i, l := range ls {
+			if l != sub.l {
+				continue
+			}
+
+			c := copyListeners(s.container[sub.hook])
+			if len(ls) == 1 {
+				delete(c, sub.priority)
+			} else {
+				c[sub.priority] = append(append(make([]*listener, 0, len(ls)-1), ls[:i]...), ls[i+1:]...)
+			}
+			s.setListeners(sub.hook, c)
+
+			return
+		}
+	})
+}
+
+// Close removes the listener, it satisfies io.Closer
+func (sub *Subscription) Close() error {
+	sub.Unlisten()
+	return nil
+}
+
+// NewRegistry returns an empty Registry
+func NewRegistry() *Registry {
+	r := &Registry{}
+	r.reset()
+
+	return r
+}
+
+// load returns the current state
+func (r *Registry) load() *state {
+	if s := r.state.Load(); s != nil {
+		return s
+	}
+
+	return newState()
+}
+
+// update replaces the state with a copy of it changed by f
+func (r *Registry) update(f func(s *state)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s := r.load().clone()
+	f(s)
+	r.state.Store(s)
 }
 
 // reset everything
-func reset() {
-	registry = make(map[string]string, 0)
-	container = make(map[string]map[int][]Doer, 0)
-	priorities = make(map[string][]int, 0)
+func (r *Registry) reset() {
+	r.state.Store(newState())
+}
+
+// Clone returns a new Registry with the same hooks and listeners,
+// later changes to either one don't affect the other
+func (r *Registry) Clone() *Registry {
+	c := &Registry{}
+	c.state.Store(r.load())
+
+	return c
 }
 
 // Register a new hook and Doer
-func Register(hook string, h Doer) {
-	if _, ok := registry[hook]; ok {
-		panic(HookError{fmt.Sprintf("duplicate hook name (%s) registered", hook)})
+func (r *Registry) Register(hook string, h Doer) {
+	if isPattern(hook) {
+		panic(HookError{fmt.Sprintf("hook name (%s) is a pattern", hook)})
 	}
 
-	registry[hook] = reflect.TypeOf(h).String()
+	r.update(func(s *state) {
+		if _, ok := s.registry[hook]; ok {
+			panic(HookError{fmt.Sprintf("duplicate hook name (%s) registered", hook)})
+		}
+
+		s.registry[hook] = reflect.TypeOf(h).String()
+	})
+}
+
+// IsRegistered reports whether the given hook has been registered
+func (r *Registry) IsRegistered(hook string) bool {
+	_, ok := r.load().registry[hook]
+	return ok
 }
 
-// Listen for a given hook with the given Doer on the given priority
-func Listen(hook string, h Doer, priority int) {
-	r := reflect.TypeOf(h).String()
-	if registry[hook] != r {
-		panic(HookError{fmt.Sprintf("%s listener is listening with wrong doer", hook)})
+// Listen for a given hook or pattern of hooks with the given Doer on the given priority
+// The returned Subscription removes the listener again
+func (r *Registry) Listen(hook string, h Doer, priority int) *Subscription {
+	sub := &Subscription{
+		r:        r,
+		hook:     hook,
+		priority: priority,
+		l:        &listener{doer: h},
 	}
 
-	if container[hook] == nil {
-		container[hook] = make(map[int][]Doer, 0)
+	r.update(func(s *state) {
+		t := reflect.TypeOf(h).String()
+		if !isPattern(hook) && s.registry[hook] != t {
+			panic(HookError{fmt.Sprintf("%s listener is listening with wrong doer", hook)})
+		}
+
+		// copy the listeners of the hook, as the current state may be in use by Do
+		c := copyListeners(s.container[hook])
+		c[priority] = append(append(make([]*listener, 0, len(c[priority])+1), c[priority]...), sub.l)
+		s.setListeners(hook, c)
+	})
+
+	return sub
+}
+
+// RemoveAll removes every listener of the given hook or pattern, a hook stays registered
+func (r *Registry) RemoveAll(hook string) {
+	r.update(func(s *state) {
+		s.setListeners(hook, nil)
+	})
+}
+
+// Unregister removes the given hook and all of its listeners
+func (r *Registry) Unregister(hook string) {
+	r.update(func(s *state) {
+		s.setListeners(hook, nil)
+		delete(s.registry, hook)
+		delete(s.options, hook)
+		delete(s.hookMiddleware, hook)
+	})
+}
+
+// Configure sets the options the given hook is dispatched with when no options are given
+func (r *Registry) Configure(hook string, opts DispatchOptions) {
+	r.update(func(s *state) {
+		if _, ok := s.registry[hook]; !ok {
+			panic(HookError{fmt.Sprintf("%s hook not found in registry", hook)})
+		}
+
+		s.options[hook] = opts
+	})
+}
+
+// setListeners replaces the listeners of the hook and caches their sorted priorities
+func (s *state) setListeners(hook string, c map[int][]*listener) {
+	if isPattern(hook) {
+		defer s.setPatterns()
 	}
-	if container[hook][priority] == nil {
-		container[hook][priority] = make([]Doer, 0)
+
+	if len(c) == 0 {
+		delete(s.container, hook)
+		delete(s.priorities, hook)
+		return
 	}
-	container[hook][priority] = append(container[hook][priority], h)
+
+	s.container[hook] = c
 
 	// sort and cache the priorities
 	n := 0
-	p := make([]int, len(container[hook]))
-	for k := range container[hook] {
+	p := make([]int, len(c))
+	for k := range c {
 		p[n] = int(k)
 		n++
 	}
 
 	sort.Ints(p)
 
-	priorities[hook] = p
+	s.priorities[hook] = p
+}
+
+// copyListeners returns a copy of the listeners of a hook that can be changed
+func copyListeners(ls map[int][]*listener) map[int][]*listener {
+	c := make(map[int][]*listener, len(ls)+1)
+	for k, v := range ls {
+		c[k] = v
+	}
+
+	return c
 }
 
 // Do the given hook with the given parameters
 // return the last continue flag
-func Do(hook string, ctx context.Context, p ...interface{}) bool {
-	if _, ok := registry[hook]; !ok {
+//
+// Listener errors are logged, use Dispatch or DoE to get them.
+func (r *Registry) Do(hook string, ctx context.Context, p ...interface{}) bool {
+	res := r.Dispatch(hook, ctx, nil, p...)
+	logErrors(ctx, res)
+
+	return !res.Halted
+}
+
+// logErrors logs the errors of the listeners of a dispatch
+func logErrors(ctx context.Context, res *Result) {
+	for _, l := range res.Listeners {
+		if l.Err != nil {
+			log.Infof(ctx, "a %s hook threw an error: %v", res.Hook, l.Err)
+		}
+	}
+}
+
+// DoE does the given hook with the given parameters like Do,
+// but returns the errors of the listeners (joined with errors.Join) instead of logging them
+func (r *Registry) DoE(hook string, ctx context.Context, p ...interface{}) (bool, error) {
+	res := r.Dispatch(hook, ctx, nil, p...)
+
+	return !res.Halted, res.Err()
+}
+
+// Dispatch does the given hook with the given parameters and options
+// (nil for the options set with Configure) and returns what happened with every listener that was called
+// No more listeners get called once ctx is done.
+func (r *Registry) Dispatch(hook string, ctx context.Context, opts *DispatchOptions, p ...interface{}) *Result {
+	s := r.load()
+	if _, ok := s.registry[hook]; !ok {
 		panic(HookError{fmt.Sprintf("%s hook not found in registry", hook)})
 	}
 
-	for _, k := range priorities[hook] {
-		for _, v := range container[hook][k] {
-			cont, err := v.Do(ctx, p...)
-			if err != nil {
-				log.Infof(ctx, fmt.Sprintf("a %s hook threw an error: %v", hook, err))
-			}
+	if opts == nil {
+		o := s.options[hook]
+		opts = &o
+	}
 
-			if !cont {
-				return false
-			}
+	res := &Result{
+		Hook:     hook,
+		HaltedBy: -1,
+	}
+
+	caller := ctx
+	ctx = context.WithValue(ctx, hookNameKey{}, hook)
+
+	if opts.HookTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.HookTimeout)
+		defer cancel()
+	}
+
+	mws := s.middlewareFor(hook)
+	for _, e := range s.listeners(hook) {
+		if ctx.Err() != nil {
+			res.Halted = true
+			res.TimedOut = timedOut(caller, ctx)
+			return res
+		}
+
+		l := call(caller, ctx, wrap(e.l.doer, mws), opts, p)
+		l.Doer = e.l.doer
+		l.Priority = e.priority
+		res.Listeners = append(res.Listeners, l)
+
+		if !l.Continue || (l.Err != nil && opts.Policy == StopOnError) {
+			res.Halted = true
+			res.HaltedBy = len(res.Listeners) - 1
+			return res
 		}
 	}
 
-	return true
+	return res
+}
+
+// timedOut reports whether ctx is done because of a timeout of the dispatch,
+// and not because the context of the caller is done
+func timedOut(caller context.Context, ctx context.Context) bool {
+	return caller.Err() == nil && errors.Is(ctx.Err(), context.DeadlineExceeded)
+}
+
+// call calls the Doer, turning a panic into a *PanicError unless panics propagate,
+// and, if there is a timeout, giving up on it when its context is done
+// A listener given up on because of a timeout gets a *TimeoutError,
+// one given up on because the context of the caller is done gets the error of that context
+func call(caller context.Context, ctx context.Context, d Doer, opts *DispatchOptions, p []interface{}) ListenerResult {
+	if opts.Timeout <= 0 && opts.HookTimeout <= 0 {
+		if opts.Panics == PropagatePanics {
+			cont, err := d.Do(ctx, p...)
+			return ListenerResult{Doer: d, Continue: cont, Err: err}
+		}
+
+		return safeDo(ctx, d, p)
+	}
+
+	lctx := ctx
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		lctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	// buffered, so the goroutine of a listener that timed out doesn't wait forever
+	done := make(chan ListenerResult, 1)
+	go func() {
+		done <- safeDo(lctx, d, p)
+	}()
+
+	select {
+	case l := <-done:
+		// the listener ran in another goroutine, so its panic is raised again in this one
+		if l.Panicked && opts.Panics == PropagatePanics {
+			panic(l.Err)
+		}
+
+		return l
+	case <-lctx.Done():
+		if !timedOut(caller, lctx) {
+			return ListenerResult{Doer: d, Continue: true, Err: lctx.Err()}
+		}
+
+		// only the listener timeout is done if the context of the hook isn't
+		var t time.Duration
+		if ctx.Err() == nil {
+			t = opts.Timeout
+		}
+
+		return ListenerResult{
+			Doer:     d,
+			Continue: true,
+			Err:      &TimeoutError{Timeout: t, Err: lctx.Err()},
+			TimedOut: true,
+		}
+	}
+}
+
+// safeDo calls the Doer, turning a panic into a *PanicError
+func safeDo(ctx context.Context, d Doer, p []interface{}) (l ListenerResult) {
+	l.Doer = d
+
+	defer func() {
+		if v := recover(); v != nil {
+			l.Continue = true
+			l.Err = &PanicError{Value: v, Stack: debug.Stack()}
+			l.Panicked = true
+		}
+	}()
+
+	l.Continue, l.Err = d.Do(ctx, p...)
+
+	return l
+}
+
+// reset everything in the DefaultRegistry
+func reset() {
+	DefaultRegistry.reset()
+}
+
+// Register a new hook and Doer in the DefaultRegistry
+func Register(hook string, h Doer) {
+	DefaultRegistry.Register(hook, h)
+}
+
+// IsRegistered reports whether the given hook has been registered in the DefaultRegistry
+func IsRegistered(hook string) bool {
+	return DefaultRegistry.IsRegistered(hook)
+}
+
+// Listen for a given hook or pattern of hooks of the DefaultRegistry with the given Doer on the given priority
+// The returned Subscription removes the listener again
+func Listen(hook string, h Doer, priority int) *Subscription {
+	return DefaultRegistry.Listen(hook, h, priority)
+}
+
+// RemoveAll removes every listener of the given hook of the DefaultRegistry
+func RemoveAll(hook string) {
+	DefaultRegistry.RemoveAll(hook)
+}
+
+// Unregister removes the given hook and all of its listeners from the DefaultRegistry
+func Unregister(hook string) {
+	DefaultRegistry.Unregister(hook)
+}
+
+// Configure sets the options the given hook of the DefaultRegistry is dispatched with when no options are given
+func Configure(hook string, opts DispatchOptions) {
+	DefaultRegistry.Configure(hook, opts)
+}
+
+// Do the given hook of the DefaultRegistry with the given parameters
+// return the last continue flag
+func Do(hook string, ctx context.Context, p ...interface{}) bool {
+	return DefaultRegistry.Do(hook, ctx, p...)
+}
+
+// DoE does the given hook of the DefaultRegistry with the given parameters,
+// returning the errors of the listeners instead of logging them
+func DoE(hook string, ctx context.Context, p ...interface{}) (bool, error) {
+	return DefaultRegistry.DoE(hook, ctx, p...)
+}
+
+// Dispatch does the given hook of the DefaultRegistry with the given parameters and options
+// and returns what happened with every listener that was called
+func Dispatch(hook string, ctx context.Context, opts *DispatchOptions, p ...interface{}) *Result {
+	return DefaultRegistry.Dispatch(hook, ctx, opts, p...)
 }
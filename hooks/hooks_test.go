@@ -4,9 +4,11 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"sync"
+	"sync/atomic"
 	"testing"
 
-	"github.com/benjamw/golibs/test"
+	"google.golang.org/appengine/aetest"
 )
 
 type TestListener struct {
@@ -25,12 +27,29 @@ func (h *TestListener) Do(ctx context.Context, p ...interface{}) (bool, error) {
 }
 
 func TestMain(m *testing.M) {
-	test.InitCtx()
+	InitCtx()
 	runVal := m.Run()
-	test.ReleaseCtx()
+	ReleaseCtx()
 	os.Exit(runVal)
 }
 
+func TestIsRegistered(t *testing.T) {
+	reset()
+	defer reset()
+
+	l := "IsRegistered"
+
+	if IsRegistered(l) {
+		t.Fatal("TestIsRegistered: hook is registered before Register")
+	}
+
+	Register(l, &TestListener{})
+
+	if !IsRegistered(l) {
+		t.Fatal("TestIsRegistered: hook is not registered after Register")
+	}
+}
+
 func TestRegister(t *testing.T) {
 	reset()
 	defer reset()
@@ -39,7 +58,7 @@ func TestRegister(t *testing.T) {
 
 	Register(l, &TestListener{})
 
-	if _, ok := registry[l]; !ok {
+	if _, ok := DefaultRegistry.load().registry[l]; !ok {
 		t.Fatal("TestRegister: registry is empty")
 	}
 }
@@ -50,44 +69,44 @@ func TestReset(t *testing.T) {
 
 	l := "Reset"
 
-	if _, ok := priorities[l]; len(priorities) > 0 || ok {
+	if _, ok := DefaultRegistry.load().priorities[l]; len(DefaultRegistry.load().priorities) > 0 || ok {
 		t.Fatal("TestReset: priorities is not empty")
 	}
 
-	if _, ok := container[l]; len(container) > 0 || ok {
+	if _, ok := DefaultRegistry.load().container[l]; len(DefaultRegistry.load().container) > 0 || ok {
 		t.Fatal("TestReset: container is not empty")
 	}
 
-	if _, ok := registry[l]; ok {
+	if _, ok := DefaultRegistry.load().registry[l]; ok {
 		t.Fatal("TestReset: registry is not empty")
 	}
 
 	Register(l, &TestListener{})
 	Listen(l, &TestListener{func(ctx context.Context, s string) (bool, error) { return true, nil }}, 10)
 
-	if _, ok := priorities[l]; !ok || len(priorities[l]) == 0 {
+	if _, ok := DefaultRegistry.load().priorities[l]; !ok || len(DefaultRegistry.load().priorities[l]) == 0 {
 		t.Fatal("TestReset: priorities is empty")
 	}
 
-	if _, ok := container[l]; !ok || len(container[l]) == 0 {
+	if _, ok := DefaultRegistry.load().container[l]; !ok || len(DefaultRegistry.load().container[l]) == 0 {
 		t.Fatal("TestReset: container is empty")
 	}
 
-	if _, ok := registry[l]; !ok {
+	if _, ok := DefaultRegistry.load().registry[l]; !ok {
 		t.Fatal("TestReset: registry is empty")
 	}
 
 	reset()
 
-	if _, ok := priorities[l]; len(priorities) > 0 || ok {
+	if _, ok := DefaultRegistry.load().priorities[l]; len(DefaultRegistry.load().priorities) > 0 || ok {
 		t.Fatal("TestReset: refreshed priorities is not empty")
 	}
 
-	if _, ok := container[l]; len(container) > 0 || ok {
+	if _, ok := DefaultRegistry.load().container[l]; len(DefaultRegistry.load().container) > 0 || ok {
 		t.Fatal("TestReset: refreshed container is not empty")
 	}
 
-	if _, ok := registry[l]; len(registry) > 0 || ok {
+	if _, ok := DefaultRegistry.load().registry[l]; len(DefaultRegistry.load().registry) > 0 || ok {
 		t.Fatal("TestReset: refreshed registry is not empty")
 	}
 }
@@ -106,16 +125,16 @@ func TestListen(t *testing.T) {
 	Listen(l, &TestListener{func(ctx context.Context, s string) (bool, error) { return true, nil }}, -100)
 	Listen(l, &TestListener{func(ctx context.Context, s string) (bool, error) { return true, nil }}, 10)
 
-	if len(priorities[l]) != 5 {
-		t.Fatalf("TestListen: incorrect number of priorities. Wanted: 5; Got: %d", len(priorities[l]))
+	if len(DefaultRegistry.load().priorities[l]) != 5 {
+		t.Fatalf("TestListen: incorrect number of priorities. Wanted: 5; Got: %d", len(DefaultRegistry.load().priorities[l]))
 	}
 
-	if len(container[l]) != 5 {
-		t.Fatalf("TestListen: incorrect number of container. Wanted: 5; Got: %d", len(container[l]))
+	if len(DefaultRegistry.load().container[l]) != 5 {
+		t.Fatalf("TestListen: incorrect number of container. Wanted: 5; Got: %d", len(DefaultRegistry.load().container[l]))
 	}
 
 	var i = -9999
-	for _, v := range priorities[l] {
+	for _, v := range DefaultRegistry.load().priorities[l] {
 		if v <= i {
 			t.Fatalf("TestListen: priorities are not sorted. %d is not less than %d", v, i)
 		}
@@ -125,7 +144,7 @@ func TestListen(t *testing.T) {
 }
 
 func TestDo(t *testing.T) {
-	ctx := test.GetCtx()
+	ctx := GetCtx()
 	reset()
 	defer reset()
 
@@ -162,7 +181,7 @@ func TestDo(t *testing.T) {
 }
 
 func TestDoPanic(t *testing.T) {
-	ctx := test.GetCtx()
+	ctx := GetCtx()
 	reset()
 	defer func() {
 		r := recover()
@@ -181,3 +200,177 @@ func TestDoPanic(t *testing.T) {
 	// running Do with no registrars should panic
 	Do(l, ctx, "test")
 }
+
+func TestRegistry(t *testing.T) {
+	ctx := GetCtx()
+	reset()
+	defer reset()
+
+	l := "Registry"
+
+	count := 0
+	counter := &TestListener{func(ctx context.Context, s string) (bool, error) {
+		count++
+		return true, nil
+	}}
+
+	r := NewRegistry()
+	r.Register(l, &TestListener{})
+	r.Listen(l, counter, 1)
+
+	if IsRegistered(l) {
+		t.Fatal("TestRegistry: a hook registered in a registry is in the default registry")
+	}
+
+	// the default registry can have its own hook of the same name
+	Register(l, &TestListener{})
+	Do(l, ctx, "test")
+	if count != 0 {
+		t.Fatal("TestRegistry: the default registry ran a listener of another registry")
+	}
+
+	c := r.Clone()
+	c.Listen(l, counter, 2)
+
+	r.Do(l, ctx, "test")
+	if count != 1 {
+		t.Fatalf("TestRegistry: a listener added to a clone ran in the original. Wanted: 1; Got: %d", count)
+	}
+
+	c.Do(l, ctx, "test")
+	if count != 3 {
+		t.Fatalf("TestRegistry: the clone did not run every listener. Wanted: 3; Got: %d", count)
+	}
+}
+
+func TestConcurrent(t *testing.T) {
+	ctx := GetCtx()
+	reset()
+	defer reset()
+
+	l := "Concurrent"
+
+	Register(l, &TestListener{})
+
+	var count atomic.Int64
+	counter := &TestListener{func(ctx context.Context, s string) (bool, error) {
+		count.Add(1)
+		return true, nil
+	}}
+
+	// listen and fire from many goroutines at once, run with -race
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			Listen(l, counter, i%5)
+		}(i)
+		go func() {
+			defer wg.Done()
+			Do(l, ctx, "test")
+			IsRegistered(l)
+		}()
+	}
+	wg.Wait()
+
+	count.Store(0)
+	Do(l, ctx, "test")
+	if count.Load() != 50 {
+		t.Fatalf("TestConcurrent: lost a listener. Wanted: 50; Got: %d", count.Load())
+	}
+
+	if len(DefaultRegistry.load().priorities[l]) != 5 {
+		t.Fatalf("TestConcurrent: incorrect number of priorities. Wanted: 5; Got: %d", len(DefaultRegistry.load().priorities[l]))
+	}
+}
+
+func TestUnlisten(t *testing.T) {
+	ctx := GetCtx()
+	reset()
+	defer reset()
+
+	l := "Unlisten"
+
+	var ran []int
+	ranner := func(i int) *TestListener {
+		return &TestListener{func(ctx context.Context, s string) (bool, error) {
+			ran = append(ran, i)
+			return true, nil
+		}}
+	}
+
+	Register(l, &TestListener{})
+	one := Listen(l, ranner(1), 1)
+	two := Listen(l, ranner(2), 2)
+	Listen(l, ranner(3), 2)
+
+	two.Unlisten()
+	two.Unlisten() // a second time does nothing
+
+	Do(l, ctx, "test")
+	if fmt.Sprint(ran) != "[1 3]" {
+		t.Fatalf("TestUnlisten: ran the wrong listeners. Wanted: [1 3]; Got: %v", ran)
+	}
+
+	if err := one.Close(); err != nil {
+		t.Fatalf("TestUnlisten: Close threw an error. Error: %v", err)
+	}
+
+	if p := DefaultRegistry.load().priorities[l]; len(p) != 1 || p[0] != 2 {
+		t.Fatalf("TestUnlisten: the priorities were not updated. Wanted: [2]; Got: %v", p)
+	}
+
+	RemoveAll(l)
+	if _, ok := DefaultRegistry.load().priorities[l]; ok || !IsRegistered(l) {
+		t.Fatal("TestUnlisten: RemoveAll did not remove only the listeners")
+	}
+
+	ran = nil
+	Do(l, ctx, "test")
+	if len(ran) != 0 {
+		t.Fatalf("TestUnlisten: ran removed listeners. Got: %v", ran)
+	}
+
+	Unregister(l)
+	if IsRegistered(l) {
+		t.Fatal("TestUnlisten: Unregister did not remove the hook")
+	}
+
+	// the hook can be registered again, with another doer
+	Register(l, &otherListener{})
+}
+
+type otherListener struct{ TestListener }
+
+// HELPER TEST FUNCS
+// Because test can't be imported as it imports db, which imports hooks
+
+var (
+	ctx      context.Context
+	doneFunc func()
+)
+
+// InitCtx initializes the testing context
+func InitCtx() {
+	c, done, err := aetest.NewContext()
+	if err != nil {
+		panic(err)
+	}
+	if c == nil {
+		panic("InitCtx failed to get a context")
+	}
+
+	ctx = c
+	doneFunc = done
+}
+
+// GetCtx returns the testing context
+func GetCtx() context.Context {
+	return ctx
+}
+
+// ReleaseCtx processes the doneFunc that clears and releases the testing context
+func ReleaseCtx() {
+	doneFunc()
+}
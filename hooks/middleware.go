@@ -0,0 +1,87 @@
+package hooks
+
+import (
+	"context"
+	"sort"
+)
+
+// Middleware wraps the call to a listener, like for timing, logging, tracing or auth checks
+// It gets the next Doer, which it should call to go on, and returns the Doer called instead.
+// The name of the hook is in the context, see HookName.
+type Middleware func(next Doer) Doer
+
+// DoerFunc is a func that is a Doer, to write middleware with
+type DoerFunc func(context.Context, ...interface{}) (bool, error)
+
+func (f DoerFunc) Do(ctx context.Context, p ...interface{}) (bool, error) {
+	return f(ctx, p...)
+}
+
+// Use adds middleware around every listener of every hook
+//
+// The middleware added with Use comes first, then the middleware of the hook added with UseHook,
+// each in the order it was added, so the first one is the outermost.
+func (r *Registry) Use(mw ...Middleware) {
+	r.update(func(s *state) {
+		s.middleware = append(append(make([]Middleware, 0, len(s.middleware)+len(mw)), s.middleware...), mw...)
+	})
+}
+
+// UseHook adds middleware around every listener of the given hook or pattern of hooks
+func (r *Registry) UseHook(hook string, mw ...Middleware) {
+	r.update(func(s *state) {
+		hm := s.hookMiddleware[hook]
+		s.hookMiddleware[hook] = append(append(make([]Middleware, 0, len(hm)+len(mw)), hm...), mw...)
+	})
+}
+
+// middlewareFor returns the middleware of the given hook, the outermost first
+func (s *state) middlewareFor(hook string) []Middleware {
+	mws := s.middleware
+	if len(s.hookMiddleware) == 0 {
+		return mws
+	}
+
+	mws = append(make([]Middleware, 0, len(mws)), mws...)
+	mws = append(mws, s.hookMiddleware[hook]...)
+	for _, p := range s.middlewarePatterns() {
+		if match(p, hook) {
+			mws = append(mws, s.hookMiddleware[p]...)
+		}
+	}
+
+	return mws
+}
+
+// middlewarePatterns returns the sorted patterns that have middleware
+func (s *state) middlewarePatterns() []string {
+	var ps []string
+	for hook := range s.hookMiddleware {
+		if isPattern(hook) {
+			ps = append(ps, hook)
+		}
+	}
+
+	sort.Strings(ps)
+
+	return ps
+}
+
+// wrap returns d wrapped in the given middleware
+func wrap(d Doer, mws []Middleware) Doer {
+	for i := len(mws) - 1; i >= 0; i-- {
+		d = mws[i](d)
+	}
+
+	return d
+}
+
+// Use adds middleware around every listener of every hook of the DefaultRegistry
+func Use(mw ...Middleware) {
+	DefaultRegistry.Use(mw...)
+}
+
+// UseHook adds middleware around every listener of the given hook or pattern of hooks of the DefaultRegistry
+func UseHook(hook string, mw ...Middleware) {
+	DefaultRegistry.UseHook(hook, mw...)
+}
@@ -0,0 +1,81 @@
+package hooks
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestMiddleware(t *testing.T) {
+	ctx := GetCtx()
+	reset()
+	defer reset()
+
+	var got []string
+	trace := func(name string) Middleware {
+		return func(next Doer) Doer {
+			return DoerFunc(func(ctx context.Context, p ...interface{}) (bool, error) {
+				got = append(got, name+">")
+				defer func() { got = append(got, "<"+name) }()
+
+				return next.Do(ctx, p...)
+			})
+		}
+	}
+
+	listener := &TestListener{func(ctx context.Context, s string) (bool, error) {
+		got = append(got, s)
+		return true, nil
+	}}
+
+	Register("user.created", &TestListener{})
+	Register("post.created", &TestListener{})
+	Listen("user.created", listener, 1)
+	Listen("post.created", listener, 1)
+
+	UseHook("user.created", trace("hook"))
+	UseHook("user.*", trace("pattern"))
+	Use(trace("a"), trace("b"))
+
+	res := Dispatch("user.created", ctx, nil, "x")
+	if want := "a> b> hook> pattern> x <pattern <hook <b <a"; strings.Join(got, " ") != want {
+		t.Fatalf("TestMiddleware: applied the middleware in the wrong order. Wanted: %s; Got: %v", want, got)
+	}
+	if res.Listeners[0].Doer != listener {
+		t.Fatalf("TestMiddleware: returned the wrapped Doer. Got: %T", res.Listeners[0].Doer)
+	}
+
+	got = nil
+	Do("post.created", ctx, "y")
+	if want := "a> b> y <b <a"; strings.Join(got, " ") != want {
+		t.Fatalf("TestMiddleware: applied the wrong middleware. Wanted: %s; Got: %v", want, got)
+	}
+}
+
+func TestMiddlewareDeny(t *testing.T) {
+	ctx := GetCtx()
+	reset()
+	defer reset()
+
+	l := "MiddlewareDeny"
+
+	errDenied := errors.New("denied")
+
+	Register(l, &TestListener{})
+	Listen(l, &TestListener{func(ctx context.Context, s string) (bool, error) {
+		t.Fatal("TestMiddlewareDeny: called a denied listener")
+		return true, nil
+	}}, 1)
+
+	UseHook(l, func(next Doer) Doer {
+		return DoerFunc(func(ctx context.Context, p ...interface{}) (bool, error) {
+			return false, errDenied
+		})
+	})
+
+	cont, err := DoE(l, ctx, "test")
+	if cont || !errors.Is(err, errDenied) {
+		t.Fatalf("TestMiddlewareDeny: returned the wrong result. Got: %t, %v", cont, err)
+	}
+}
@@ -0,0 +1,88 @@
+package hooks
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrorPolicy decides what Dispatch does when a listener returns an error
+type ErrorPolicy int
+
+const (
+	// ContinueOnError keeps calling the listeners after one returns an error (the default, like Do)
+	ContinueOnError ErrorPolicy = iota
+
+	// StopOnError halts the hook at the first listener that returns an error
+	StopOnError
+)
+
+// PanicPolicy decides what Dispatch does when a listener panics
+type PanicPolicy int
+
+const (
+	// RecoverPanics turns the panic into a *PanicError of the listener (the default)
+	RecoverPanics PanicPolicy = iota
+
+	// PropagatePanics lets the panic through to the caller of Dispatch,
+	// as a *PanicError if the listener ran in another goroutine because of a timeout
+	PropagatePanics
+)
+
+// DispatchOptions configures a Dispatch
+//
+// A listener that panics gets a *PanicError, a listener that is still running when its timeout is up
+// gets a *TimeoutError and keeps running on its own, so it should stop when its context is done.
+type DispatchOptions struct {
+	Policy      ErrorPolicy
+	Panics      PanicPolicy
+	Timeout     time.Duration // how long each listener can take, 0 for no limit
+	HookTimeout time.Duration // how long all the listeners together can take, 0 for no limit
+}
+
+// ListenerResult describes a call to a listener
+type ListenerResult struct {
+	Priority int   // the priority the listener listens on
+	Doer     Doer  // the listener
+	Continue bool  // the continue flag it returned
+	Err      error // the error it returned
+	Panicked bool  // whether it panicked, Err is a *PanicError
+	TimedOut bool  // whether it did not return in time, Err is a *TimeoutError
+}
+
+// Result describes a Dispatch of a hook
+type Result struct {
+	Hook      string
+	Listeners []ListenerResult // every listener that was called, in order
+	Halted    bool             // whether a listener stopped the hook
+	HaltedBy  int              // the index in Listeners of the listener that stopped the hook, -1 if none did
+	TimedOut  bool             // whether the hook timeout stopped the hook
+}
+
+// Offenders returns the listeners that panicked or did not return in time
+func (r *Result) Offenders() []ListenerResult {
+	var ls []ListenerResult
+	for _, l := range r.Listeners {
+		if l.Panicked || l.TimedOut {
+			ls = append(ls, l)
+		}
+	}
+
+	return ls
+}
+
+// Err returns the errors of the listeners joined with errors.Join, each one as a *ListenerError,
+// or nil if none of them returned an error
+func (r *Result) Err() error {
+	var errs []error
+	for _, l := range r.Listeners {
+		if l.Err != nil {
+			errs = append(errs, &ListenerError{
+				Hook:     r.Hook,
+				Priority: l.Priority,
+				Err:      l.Err,
+			})
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,252 @@
+package hooks
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDispatch(t *testing.T) {
+	ctx := GetCtx()
+	reset()
+	defer reset()
+
+	l := "Dispatch"
+
+	errOne := errors.New("one")
+	errTwo := errors.New("two")
+
+	Register(l, &TestListener{})
+	Listen(l, &TestListener{func(ctx context.Context, s string) (bool, error) { return true, errOne }}, 1)
+	Listen(l, &TestListener{func(ctx context.Context, s string) (bool, error) { return true, nil }}, 2)
+	Listen(l, &TestListener{func(ctx context.Context, s string) (bool, error) { return true, errTwo }}, 3)
+	Listen(l, &TestListener{func(ctx context.Context, s string) (bool, error) { return false, nil }}, 4)
+	Listen(l, &TestListener{func(ctx context.Context, s string) (bool, error) { return true, nil }}, 5)
+
+	res := Dispatch(l, ctx, nil, "test")
+	if len(res.Listeners) != 4 || !res.Halted || res.HaltedBy != 3 || res.Listeners[3].Priority != 4 {
+		t.Fatalf("TestDispatch: returned the wrong result. Got: %+v", res)
+	}
+
+	err := res.Err()
+	if !errors.Is(err, errOne) || !errors.Is(err, errTwo) {
+		t.Fatalf("TestDispatch: did not aggregate the errors. Got: %v", err)
+	}
+
+	var le *ListenerError
+	if !errors.As(err, &le) || le.Hook != l || le.Priority != 1 {
+		t.Fatalf("TestDispatch: did not wrap the errors in a ListenerError. Got: %v", err)
+	}
+
+	// stop on the first error
+	res = Dispatch(l, ctx, &DispatchOptions{Policy: StopOnError}, "test")
+	if len(res.Listeners) != 1 || !res.Halted || res.HaltedBy != 0 || !errors.Is(res.Err(), errOne) {
+		t.Fatalf("TestDispatch: did not stop on the first error. Got: %+v", res)
+	}
+
+	cont, err := DoE(l, ctx, "test")
+	if cont || !errors.Is(err, errTwo) {
+		t.Fatalf("TestDispatch: DoE returned the wrong result. Got: %t, %v", cont, err)
+	}
+
+	RemoveAll(l)
+	if res = Dispatch(l, ctx, nil, "test"); res.Halted || res.HaltedBy != -1 || res.Err() != nil {
+		t.Fatalf("TestDispatch: returned the wrong result without listeners. Got: %+v", res)
+	}
+}
+
+func TestDispatchPanic(t *testing.T) {
+	ctx := GetCtx()
+	reset()
+	defer reset()
+
+	l := "DispatchPanic"
+
+	errBoom := errors.New("boom")
+
+	var after bool
+	Register(l, &TestListener{})
+	Listen(l, &TestListener{func(ctx context.Context, s string) (bool, error) { panic(errBoom) }}, 1)
+	Listen(l, &TestListener{func(ctx context.Context, s string) (bool, error) {
+		after = true
+		return true, nil
+	}}, 2)
+
+	res := Dispatch(l, ctx, nil, "test")
+	if len(res.Listeners) != 2 || res.Halted || !after || !res.Listeners[0].Panicked {
+		t.Fatalf("TestDispatchPanic: did not recover the panic. Got: %+v", res)
+	}
+
+	var pe *PanicError
+	if !errors.As(res.Err(), &pe) || !errors.Is(res.Err(), errBoom) || len(pe.Stack) == 0 {
+		t.Fatalf("TestDispatchPanic: did not return a PanicError. Got: %v", res.Err())
+	}
+
+	if o := res.Offenders(); len(o) != 1 || o[0].Priority != 1 {
+		t.Fatalf("TestDispatchPanic: returned the wrong offenders. Got: %+v", o)
+	}
+
+	if !Do(l, ctx, "test") {
+		t.Fatal("TestDispatchPanic: Do returned a false continue flag after a panic")
+	}
+}
+
+func TestDispatchTimeout(t *testing.T) {
+	ctx := GetCtx()
+	reset()
+	defer reset()
+
+	l := "DispatchTimeout"
+
+	var calls int32
+	Register(l, &TestListener{})
+	Listen(l, &TestListener{func(ctx context.Context, s string) (bool, error) {
+		<-ctx.Done()
+		return true, nil
+	}}, 1)
+	Listen(l, &TestListener{func(ctx context.Context, s string) (bool, error) {
+		atomic.AddInt32(&calls, 1)
+		return true, nil
+	}}, 2)
+
+	res := Dispatch(l, ctx, &DispatchOptions{Timeout: 10 * time.Millisecond}, "test")
+	if len(res.Listeners) != 2 || !res.Listeners[0].TimedOut || res.TimedOut || atomic.LoadInt32(&calls) != 1 {
+		t.Fatalf("TestDispatchTimeout: did not time out the listener. Got: %+v", res)
+	}
+
+	var te *TimeoutError
+	if !errors.As(res.Err(), &te) || te.Timeout != 10*time.Millisecond || !errors.Is(res.Err(), context.DeadlineExceeded) {
+		t.Fatalf("TestDispatchTimeout: did not return a TimeoutError. Got: %v", res.Err())
+	}
+
+	// the hook timeout stops the listeners after it
+	Configure(l, DispatchOptions{HookTimeout: 10 * time.Millisecond})
+	res = Dispatch(l, ctx, nil, "test")
+	if len(res.Listeners) != 1 || !res.Listeners[0].TimedOut || !res.TimedOut || !res.Halted || atomic.LoadInt32(&calls) != 1 {
+		t.Fatalf("TestDispatchTimeout: did not time out the hook. Got: %+v", res)
+	}
+	if !errors.As(res.Err(), &te) || te.Timeout != 0 {
+		t.Fatalf("TestDispatchTimeout: did not return a TimeoutError for the hook. Got: %v", res.Err())
+	}
+}
+
+func TestFilterTimeout(t *testing.T) {
+	ctx := GetCtx()
+	reset()
+	defer reset()
+
+	f := NewFilter[int]("FilterTimeout")
+	f.Configure(DispatchOptions{Timeout: 10 * time.Millisecond})
+
+	f.Listen(func(ctx context.Context, v int) (int, error) { return v + 1, nil }, 1)
+	f.Listen(func(ctx context.Context, v int) (int, error) {
+		<-ctx.Done()
+		return -1, nil
+	}, 2)
+
+	v, err := f.Apply(ctx, 1)
+	if v != 2 || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("TestFilterTimeout: returned the wrong value. Got: %d, %v", v, err)
+	}
+
+	// the value of a listener whose context got done while it ran is dropped
+	lctx, cancel := context.WithCancel(ctx)
+	d := &filterDoer[int]{func(ctx context.Context, v int) (int, error) {
+		cancel()
+		return -1, nil
+	}}
+	fv := &filterValue[int]{v: 2}
+	d.Do(lctx, fv)
+	if fv.get() != 2 {
+		t.Fatal("TestFilterTimeout: kept the value of a listener that timed out")
+	}
+}
+
+func TestDispatchCancel(t *testing.T) {
+	reset()
+	defer reset()
+
+	l := "DispatchCancel"
+
+	release := make(chan struct{})
+	defer close(release)
+
+	Register(l, &TestListener{})
+	Listen(l, &TestListener{func(ctx context.Context, s string) (bool, error) {
+		<-release
+		return true, nil
+	}}, 1)
+	Listen(l, &TestListener{func(ctx context.Context, s string) (bool, error) {
+		t.Fatal("TestDispatchCancel: called a listener after the caller was done")
+		return true, nil
+	}}, 2)
+
+	// the caller giving up is not a timeout
+	ctx, cancel := context.WithCancel(GetCtx())
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	res := Dispatch(l, ctx, &DispatchOptions{Timeout: time.Hour, HookTimeout: time.Hour}, "test")
+	if len(res.Listeners) != 1 || res.Listeners[0].TimedOut || res.TimedOut || !res.Halted {
+		t.Fatalf("TestDispatchCancel: reported a cancellation as a timeout. Got: %+v", res)
+	}
+
+	var te *TimeoutError
+	if err := res.Err(); !errors.Is(err, context.Canceled) || errors.As(err, &te) {
+		t.Fatalf("TestDispatchCancel: returned the wrong error. Got: %v", err)
+	}
+}
+
+func TestDispatchCancelTimeout(t *testing.T) {
+	reset()
+	defer reset()
+
+	l := "DispatchCancelTimeout"
+
+	Register(l, &TestListener{})
+	Listen(l, &TestListener{func(ctx context.Context, s string) (bool, error) {
+		t.Fatal("TestDispatchCancelTimeout: called a listener after the caller was done")
+		return true, nil
+	}}, 1)
+
+	// only the listener timeout is set, the caller is already done
+	ctx, cancel := context.WithCancel(GetCtx())
+	cancel()
+
+	res := Dispatch(l, ctx, &DispatchOptions{Timeout: time.Hour}, "test")
+	if len(res.Listeners) != 0 || res.TimedOut || !res.Halted {
+		t.Fatalf("TestDispatchCancelTimeout: did not stop before the listeners. Got: %+v", res)
+	}
+}
+
+func TestDispatchPropagatePanics(t *testing.T) {
+	ctx := GetCtx()
+	reset()
+	defer reset()
+
+	l := "DispatchPropagatePanics"
+
+	Register(l, &TestListener{})
+	Listen(l, &TestListener{func(ctx context.Context, s string) (bool, error) { panic("boom") }}, 1)
+	Configure(l, DispatchOptions{Panics: PropagatePanics})
+
+	dispatch := func(opts *DispatchOptions) (v interface{}) {
+		defer func() { v = recover() }()
+		Dispatch(l, ctx, opts, "test")
+		return
+	}
+
+	if v := dispatch(nil); v != "boom" {
+		t.Fatalf("TestDispatchPropagatePanics: did not propagate the panic. Got: %v", v)
+	}
+
+	// a listener that runs in another goroutine panics with a PanicError
+	if v, ok := dispatch(&DispatchOptions{Panics: PropagatePanics, Timeout: time.Hour}).(*PanicError); !ok || v.Value != "boom" {
+		t.Fatalf("TestDispatchPropagatePanics: did not propagate the panic of another goroutine. Got: %v", v)
+	}
+
+	if v := dispatch(&DispatchOptions{}); v != nil {
+		t.Fatalf("TestDispatchPropagatePanics: did not recover the panic. Got: %v", v)
+	}
+}
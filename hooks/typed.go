@@ -0,0 +1,105 @@
+package hooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// ErrHalt can be returned by a typed listener to stop the hook from calling the listeners after it
+var ErrHalt = errors.New("halt")
+
+// Hook is a hook whose listeners get a T, so a listener or a Do call with the wrong parameters doesn't compile
+// Hooks with several parameters use a struct as T.
+type Hook[T any] struct {
+	r    *Registry
+	name string
+}
+
+// NewHook registers a hook with the given name in the DefaultRegistry
+func NewHook[T any](name string) *Hook[T] {
+	return NewHookIn[T](DefaultRegistry, name)
+}
+
+// NewHookIn registers a hook with the given name in the given Registry
+func NewHookIn[T any](r *Registry, name string) *Hook[T] {
+	r.Register(name, &typedDoer[T]{})
+
+	return &Hook[T]{r: r, name: name}
+}
+
+// LookupHook returns the hook with the given name in the DefaultRegistry,
+// if it is registered as a Hook[T]
+func LookupHook[T any](name string) (*Hook[T], bool) {
+	return LookupHookIn[T](DefaultRegistry, name)
+}
+
+// LookupHookIn returns the hook with the given name in the given Registry,
+// if it is registered as a Hook[T]
+func LookupHookIn[T any](r *Registry, name string) (*Hook[T], bool) {
+	if r.load().registry[name] != reflect.TypeOf(&typedDoer[T]{}).String() {
+		return nil, false
+	}
+
+	return &Hook[T]{r: r, name: name}, true
+}
+
+// Name returns the name of the hook in its Registry
+func (h *Hook[T]) Name() string {
+	return h.name
+}
+
+// Listen for the hook with the given func on the given priority
+// If f returns ErrHalt, the listeners after it are not called, any other error gets logged
+func (h *Hook[T]) Listen(f func(context.Context, T) error, priority int) *Subscription {
+	return h.r.Listen(h.name, &typedDoer[T]{f}, priority)
+}
+
+// Configure sets the options the hook is dispatched with when no options are given
+func (h *Hook[T]) Configure(opts DispatchOptions) {
+	h.r.Configure(h.name, opts)
+}
+
+// Do the hook with the given value
+// return the last continue flag
+func (h *Hook[T]) Do(ctx context.Context, v T) bool {
+	return h.r.Do(h.name, ctx, v)
+}
+
+// DoE does the hook with the given value, returning the errors of the listeners instead of logging them
+func (h *Hook[T]) DoE(ctx context.Context, v T) (bool, error) {
+	return h.r.DoE(h.name, ctx, v)
+}
+
+// Dispatch does the hook with the given value and options
+// and returns what happened with every listener that was called
+func (h *Hook[T]) Dispatch(ctx context.Context, v T, opts *DispatchOptions) *Result {
+	return h.r.Dispatch(h.name, ctx, opts, v)
+}
+
+// typedDoer is the Doer of a Hook[T]
+type typedDoer[T any] struct {
+	f func(context.Context, T) error
+}
+
+func (d *typedDoer[T]) Do(ctx context.Context, p ...interface{}) (bool, error) {
+	if len(p) != 1 {
+		panic(HookError{fmt.Sprintf("typed hook called with %d parameters", len(p))})
+	}
+
+	v, ok := p[0].(T)
+	if !ok && p[0] != nil {
+		panic(HookError{fmt.Sprintf("typed hook called with a %T", p[0])})
+	}
+
+	if err := d.f(ctx, v); err != nil {
+		if errors.Is(err, ErrHalt) {
+			return false, nil
+		}
+
+		return true, err
+	}
+
+	return true, nil
+}
@@ -0,0 +1,96 @@
+package hooks
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+type userEvent struct {
+	Name string
+	Age  int
+}
+
+func TestHook(t *testing.T) {
+	ctx := GetCtx()
+	reset()
+	defer reset()
+
+	h := NewHook[*userEvent]("Hook")
+	if h.Name() != "Hook" || !IsRegistered("Hook") {
+		t.Fatal("TestHook: NewHook did not register the hook")
+	}
+
+	var got []string
+	h.Listen(func(ctx context.Context, e *userEvent) error {
+		got = append(got, e.Name)
+		return errors.New("logged, but not halting")
+	}, 1)
+	h.Listen(func(ctx context.Context, e *userEvent) error {
+		e.Age++
+		return ErrHalt
+	}, 2)
+	h.Listen(func(ctx context.Context, e *userEvent) error {
+		t.Fatal("TestHook: ran a listener after a halt")
+		return nil
+	}, 3)
+
+	e := &userEvent{Name: "alice"}
+	if h.Do(ctx, e) {
+		t.Fatal("TestHook: returned a true continue flag when it should have halted")
+	}
+
+	if len(got) != 1 || got[0] != "alice" || e.Age != 1 {
+		t.Fatalf("TestHook: the listeners did not get the value. Got: %v, %+v", got, e)
+	}
+}
+
+func TestHookWrongDoer(t *testing.T) {
+	reset()
+	defer reset()
+
+	NewHook[string]("HookWrongDoer")
+
+	defer func() {
+		if _, ok := recover().(HookError); !ok {
+			t.Fatal("TestHookWrongDoer: listening with an untyped doer did not panic")
+		}
+	}()
+
+	Listen("HookWrongDoer", &TestListener{}, 1)
+}
+
+func TestLookupHook(t *testing.T) {
+	ctx := GetCtx()
+	reset()
+	defer reset()
+
+	if _, ok := LookupHook[string]("LookupHook"); ok {
+		t.Fatal("TestLookupHook: found a hook that is not registered")
+	}
+
+	NewHook[string]("LookupHook")
+
+	h, ok := LookupHook[string]("LookupHook")
+	if !ok {
+		t.Fatal("TestLookupHook: did not find a registered hook")
+	}
+	if _, ok = LookupHook[int]("LookupHook"); ok {
+		t.Fatal("TestLookupHook: found a hook of another type")
+	}
+
+	var got string
+	h.Listen(func(ctx context.Context, s string) error {
+		got = s
+		return nil
+	}, 1)
+	h.Do(ctx, "found")
+	if got != "found" {
+		t.Fatalf("TestLookupHook: the hook does not dispatch. Got: %q", got)
+	}
+
+	Unregister("LookupHook")
+	if _, ok = LookupHook[string]("LookupHook"); ok {
+		t.Fatal("TestLookupHook: found an unregistered hook")
+	}
+}
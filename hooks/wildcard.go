@@ -0,0 +1,115 @@
+package hooks
+
+import (
+	"context"
+	"sort"
+	"strings"
+)
+
+// Hook names are dot separated, like "user.created", and Listen also takes patterns of them:
+// a "*" segment matches any one segment ("user.*", "*.deleted")
+// and a "**" segment matches any number of segments, even none ("**", "user.**").
+//
+// The listeners of a pattern are called for every matching hook with the parameters of that hook,
+// so they can't be checked against the Doer of the hook like other listeners and should check the parameters themselves.
+// At each priority the listeners of the hook itself are called first,
+// then those of the matching patterns, in the order of the patterns.
+
+// hookNameKey is the context key of the name of the hook being dispatched
+type hookNameKey struct{}
+
+// HookName returns the name of the hook being dispatched, for listeners of a pattern
+func HookName(ctx context.Context) string {
+	name, _ := ctx.Value(hookNameKey{}).(string)
+	return name
+}
+
+// isPattern reports whether the given hook name has a wildcard segment
+func isPattern(hook string) bool {
+	for _, seg := range strings.Split(hook, ".") {
+		if seg == "*" || seg == "**" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// match reports whether the given pattern matches the given hook name
+func match(pattern string, hook string) bool {
+	return matchSegments(strings.Split(pattern, "."), strings.Split(hook, "."))
+}
+
+func matchSegments(pattern []string, hook []string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case "**":
+			for i := 0; i <= len(hook); i++ {
+				if matchSegments(pattern[1:], hook[i:]) {
+					return true
+				}
+			}
+
+			return false
+		case "*":
+			if len(hook) == 0 {
+				return false
+			}
+		default:
+			if len(hook) == 0 || pattern[0] != hook[0] {
+				return false
+			}
+		}
+
+		pattern = pattern[1:]
+		hook = hook[1:]
+	}
+
+	return len(hook) == 0
+}
+
+// setPatterns caches the sorted patterns that have listeners
+func (s *state) setPatterns() {
+	s.patterns = nil
+	for hook := range s.container {
+		if isPattern(hook) {
+			s.patterns = append(s.patterns, hook)
+		}
+	}
+
+	sort.Strings(s.patterns)
+}
+
+// entry is a listener with its priority
+type entry struct {
+	priority int
+	l        *listener
+}
+
+// listeners returns the listeners of the given hook and of the patterns matching it, in the order they are called
+func (s *state) listeners(hook string) []entry {
+	hooks := []string{hook}
+	for _, p := range s.patterns {
+		if match(p, hook) {
+			hooks = append(hooks, p)
+		}
+	}
+
+	var es []entry
+	for _, h := range hooks {
+		for _, k := range s.priorities[h] {
+			for _, l := range s.container[h][k] {
+				es = append(es, entry{k, l})
+			}
+		}
+	}
+
+	// the hook comes first, so a stable sort keeps it before the patterns at each priority
+	if len(hooks) > 1 {
+		sort.SliceStable(es, func(i, j int) bool {
+			return es[i].priority < es[j].priority
+		})
+	}
+
+	return es
+}
@@ -0,0 +1,90 @@
+package hooks
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		hook    string
+		want    bool
+	}{
+		{"user.*", "user.created", true},
+		{"user.*", "user", false},
+		{"user.*", "user.created.late", false},
+		{"*.deleted", "user.deleted", true},
+		{"*.deleted", "user.created", false},
+		{"**", "user", true},
+		{"**", "user.created.late", true},
+		{"user.**", "user", true},
+		{"user.**", "user.created.late", true},
+		{"user.**", "post.created", false},
+		{"**.deleted", "user.profile.deleted", true},
+		{"user.*.late", "user.created.late", true},
+		{"user.*.late", "user.late", false},
+	}
+
+	for _, test := range tests {
+		if got := match(test.pattern, test.hook); got != test.want {
+			t.Errorf("TestMatch: %s matched %s wrong. Wanted: %t; Got: %t", test.pattern, test.hook, test.want, got)
+		}
+	}
+}
+
+func TestWildcard(t *testing.T) {
+	ctx := GetCtx()
+	reset()
+	defer reset()
+
+	var got []string
+	record := func(name string) *TestListener {
+		return &TestListener{func(ctx context.Context, s string) (bool, error) {
+			got = append(got, name+":"+HookName(ctx))
+			return true, nil
+		}}
+	}
+
+	Register("user.created", &TestListener{})
+	Register("user.deleted", &TestListener{})
+	Register("post.deleted", &TestListener{})
+
+	Listen("user.deleted", record("exact"), 2)
+	Listen("*.deleted", record("deleted"), 2)
+	Listen("**", record("all"), 1)
+	sub := Listen("user.*", record("user"), 0)
+
+	Do("user.deleted", ctx, "test")
+	if want := "user:user.deleted all:user.deleted exact:user.deleted deleted:user.deleted"; strings.Join(got, " ") != want {
+		t.Fatalf("TestWildcard: called the wrong listeners. Wanted: %s; Got: %v", want, got)
+	}
+
+	got = nil
+	Do("post.deleted", ctx, "test")
+	if want := "all:post.deleted deleted:post.deleted"; strings.Join(got, " ") != want {
+		t.Fatalf("TestWildcard: called the wrong listeners. Wanted: %s; Got: %v", want, got)
+	}
+
+	got = nil
+	sub.Unlisten()
+	RemoveAll("**")
+	Do("user.created", ctx, "test")
+	if len(got) != 0 || len(DefaultRegistry.load().patterns) != 1 {
+		t.Fatalf("TestWildcard: called removed listeners. Got: %v", got)
+	}
+}
+
+func TestRegisterPattern(t *testing.T) {
+	reset()
+	defer reset()
+
+	defer func() {
+		if _, ok := recover().(HookError); !ok {
+			t.Fatal("TestRegisterPattern: registering a pattern did not panic")
+		}
+	}()
+
+	Register("user.*", &TestListener{})
+}
@@ -0,0 +1,238 @@
+package test
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"google.golang.org/appengine/datastore"
+	"gopkg.in/yaml.v3"
+
+	"github.com/benjamw/golibs/db"
+)
+
+// fixtureBatchSize is the most fixtures saved with a single db.SaveMulti
+const fixtureBatchSize = 500
+
+// Fixture describes an entity to load into the testing datastore
+// A fixture file (YAML, or JSON which is valid YAML) holds a list of them, see testdata/fixtures.yaml
+//
+// ID is an int ID or a string name, an ID gets allocated if it's missing.
+// Parent is either "@label" of an earlier fixture or a key path: [Kind, id or name, Kind, id or name, ...].
+// Property lists are multi-valued properties, "@label" values are the key of another fixture
+// ("@@" escapes a leading @), and RFC 3339 strings (like 2006-01-02T15:04:05Z) are times.
+type Fixture struct {
+	Label      string                 `yaml:"label"`
+	Kind       string                 `yaml:"kind"`
+	ID         interface{}            `yaml:"id"`
+	Parent     interface{}            `yaml:"parent"`
+	Properties map[string]interface{} `yaml:"properties"`
+	NoIndex    []string               `yaml:"noindex"`
+}
+
+// LoadFixtures loads the fixtures in the given files into the testing context with db.SaveMulti
+// and returns the keys of the labelled fixtures
+func LoadFixtures(files ...string) (keys map[string]*datastore.Key, myerr error) {
+	var fixtures []Fixture
+	for _, f := range files {
+		var b []byte
+		if b, myerr = os.ReadFile(f); myerr != nil {
+			return
+		}
+
+		var fs []Fixture
+		if myerr = yaml.Unmarshal(b, &fs); myerr != nil {
+			myerr = fmt.Errorf("fixtures %s: %w", f, myerr)
+			return
+		}
+
+		fixtures = append(fixtures, fs...)
+	}
+
+	return SaveFixtures(fixtures)
+}
+
+// SaveFixtures saves the given fixtures into the testing context with db.SaveMulti
+// and returns the keys of the labelled fixtures
+func SaveFixtures(fixtures []Fixture) (keys map[string]*datastore.Key, myerr error) {
+	keys = make(map[string]*datastore.Key)
+
+	// every key is set before any property, so properties can reference later fixtures
+	entities := make([]*db.Entity, len(fixtures))
+	for i, f := range fixtures {
+		var k *datastore.Key
+		if k, myerr = fixtureKey(f, keys); myerr != nil {
+			return nil, myerr
+		}
+
+		if f.Label != "" {
+			if _, ok := keys[f.Label]; ok {
+				return nil, fmt.Errorf("fixture %q is defined twice", f.Label)
+			}
+			keys[f.Label] = k
+		}
+
+		entities[i] = db.NewEntity(f.Kind)
+		entities[i].SetKey(k)
+	}
+
+	models := make([]db.Model, len(entities))
+	for i, f := range fixtures {
+		// in name order, so the entities and errors are the same on every run
+		names := make([]string, 0, len(f.Properties))
+		for name := range f.Properties {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			var pl datastore.PropertyList
+			if pl, myerr = fixtureProperties(name, f.Properties[name], keys); myerr != nil {
+				return nil, fmt.Errorf("fixture %q: %w", f.Label, myerr)
+			}
+
+			for j := range pl {
+				pl[j].NoIndex = containsString(f.NoIndex, name)
+			}
+
+			entities[i].Properties = append(entities[i].Properties, pl...)
+		}
+
+		models[i] = entities[i]
+	}
+
+	for 0 < len(models) {
+		chunk := models
+		if len(chunk) > fixtureBatchSize {
+			chunk = chunk[:fixtureBatchSize]
+		}
+		models = models[len(chunk):]
+
+		if myerr = db.SaveMulti(ctx, chunk); myerr != nil {
+			return nil, myerr
+		}
+	}
+
+	return
+}
+
+// fixtureKey returns the complete key of the fixture, allocating an ID if it doesn't have one
+func fixtureKey(f Fixture, keys map[string]*datastore.Key) (k *datastore.Key, myerr error) {
+	if f.Kind == "" {
+		return nil, fmt.Errorf("fixture %q has no kind", f.Label)
+	}
+
+	var parent *datastore.Key
+	switch p := f.Parent.(type) {
+	case nil:
+	case string:
+		var ok bool
+		if parent, ok = keys[strings.TrimPrefix(p, "@")]; !ok || !strings.HasPrefix(p, "@") {
+			return nil, fmt.Errorf("fixture %q has an unknown parent %q, parents have to be defined first", f.Label, p)
+		}
+	case []interface{}:
+		if len(p)%2 != 0 {
+			return nil, fmt.Errorf("fixture %q has an odd parent path", f.Label)
+		}
+
+		for i := 0; i < len(p); i += 2 {
+			kind, _ := p[i].(string)
+			if parent, myerr = fixtureNewKey(kind, p[i+1], parent); myerr != nil {
+				return nil, fmt.Errorf("fixture %q: %w", f.Label, myerr)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("fixture %q has an invalid parent %v", f.Label, f.Parent)
+	}
+
+	if f.ID == nil {
+		var allocated []*datastore.Key
+		if allocated, myerr = db.AllocateIDs(ctx, f.Kind, 1, parent); myerr != nil {
+			return
+		}
+
+		return allocated[0], nil
+	}
+
+	if k, myerr = fixtureNewKey(f.Kind, f.ID, parent); myerr != nil {
+		myerr = fmt.Errorf("fixture %q: %w", f.Label, myerr)
+	}
+
+	return
+}
+
+func fixtureNewKey(kind string, id interface{}, parent *datastore.Key) (*datastore.Key, error) {
+	switch v := id.(type) {
+	case int:
+		return datastore.NewKey(ctx, kind, "", int64(v), parent), nil
+	case string:
+		return datastore.NewKey(ctx, kind, v, 0, parent), nil
+	}
+
+	return nil, fmt.Errorf("invalid %s id %v", kind, id)
+}
+
+// fixtureProperties converts a decoded fixture value into properties
+func fixtureProperties(name string, v interface{}, keys map[string]*datastore.Key) (pl datastore.PropertyList, myerr error) {
+	if vs, ok := v.([]interface{}); ok {
+		for _, e := range vs {
+			p := datastore.Property{Name: name, Multiple: true}
+			if p.Value, myerr = fixtureValue(e, keys); myerr != nil {
+				return
+			}
+			pl = append(pl, p)
+		}
+
+		return
+	}
+
+	p := datastore.Property{Name: name}
+	if p.Value, myerr = fixtureValue(v, keys); myerr != nil {
+		return
+	}
+
+	return datastore.PropertyList{p}, nil
+}
+
+func fixtureValue(v interface{}, keys map[string]*datastore.Key) (interface{}, error) {
+	switch t := v.(type) {
+	case nil, bool, float64, time.Time:
+		return t, nil
+	case int:
+		return int64(t), nil
+	case string:
+		if strings.HasPrefix(t, "@@") {
+			return t[1:], nil
+		}
+
+		if strings.HasPrefix(t, "@") {
+			k, ok := keys[t[1:]]
+			if !ok {
+				return nil, fmt.Errorf("unknown fixture reference %q", t)
+			}
+
+			return k, nil
+		}
+
+		// JSON has no timestamps, so times in JSON fixtures (and quoted YAML ones) are strings
+		if tm, err := time.Parse(time.RFC3339Nano, t); err == nil {
+			return tm, nil
+		}
+
+		return t, nil
+	}
+
+	return nil, fmt.Errorf("unsupported fixture value %v (%T)", v, v)
+}
+
+func containsString(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,112 @@
+package test
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/appengine/datastore"
+
+	"github.com/benjamw/golibs/db"
+)
+
+func TestMain(m *testing.M) {
+	InitCtx()
+	runVal := m.Run()
+	ReleaseCtx()
+	os.Exit(runVal)
+}
+
+func TestLoadFixtures(t *testing.T) {
+	defer ResetDB()
+
+	keys, err := LoadFixtures("testdata/fixtures.yaml")
+	if err != nil {
+		t.Fatalf("LoadFixtures threw an error. Error: %v", err)
+	}
+	if len(keys) != 3 {
+		t.Fatalf("LoadFixtures returned the wrong number of keys. Wanted: 3; Got: %d", len(keys))
+	}
+
+	if keys["alice"].IntID() != 12 || keys["bob"].StringID() != "bob" {
+		t.Fatalf("LoadFixtures returned the wrong keys. Got: %v", keys)
+	}
+	if k := keys["note"]; k.Incomplete() || !k.Parent().Equal(keys["alice"]) {
+		t.Fatalf("LoadFixtures did not allocate the key under the parent. Got: %v", k)
+	}
+
+	alice := db.NewEntity("Person")
+	if _, err = db.Load(ctx, keys["alice"], alice); err != nil {
+		t.Fatalf("Load threw an error. Error: %v", err)
+	}
+
+	props := make(map[string][]interface{})
+	for _, p := range alice.Properties {
+		props[p.Name] = append(props[p.Name], p.Value)
+	}
+
+	if props["Name"][0] != "Alice" || props["Age"][0] != int64(30) || len(props["Tags"]) != 2 || props["Handle"][0] != "@alice" {
+		t.Fatalf("LoadFixtures saved the wrong properties. Got: %v", props)
+	}
+	if born, ok := props["Born"][0].(time.Time); !ok || !born.Equal(time.Date(1990, 5, 1, 10, 0, 0, 0, time.UTC)) {
+		t.Fatalf("LoadFixtures did not save the time. Got: %T: %v", props["Born"][0], props["Born"][0])
+	}
+	if friend, ok := props["Friend"][0].(*datastore.Key); !ok || !friend.Equal(keys["bob"]) {
+		t.Fatalf("LoadFixtures did not resolve the reference. Got: %v", props["Friend"])
+	}
+
+	n, err := datastore.NewQuery("Note").Ancestor(keys["alice"]).Count(ctx)
+	if err != nil || n != 2 {
+		t.Fatalf("LoadFixtures did not save the children. Error: %v; Got: %d", err, n)
+	}
+}
+
+func TestLoadFixturesErrors(t *testing.T) {
+	defer ResetDB()
+
+	fixtures := [][]Fixture{
+		{{Label: "a"}},
+		{{Label: "a", Kind: "A", Parent: "@missing"}},
+		{{Label: "a", Kind: "A", Properties: map[string]interface{}{"B": "@missing"}}},
+		{{Label: "a", Kind: "A", ID: 1}, {Label: "a", Kind: "A", ID: 2}},
+	}
+
+	for i, fs := range fixtures {
+		if _, err := SaveFixtures(fs); err == nil {
+			t.Fatalf("SaveFixtures did not throw an error for the invalid fixtures %d", i)
+		}
+	}
+}
+
+func TestSaveFixturesOrder(t *testing.T) {
+	defer ResetDB()
+
+	// the properties are converted in name order, so the first bad one is always reported
+	props := map[string]interface{}{"Z": "@zzz", "A": "@aaa", "M": "@mmm", "B": "@bbb"}
+	for i := 0; i < 20; i++ {
+		_, err := SaveFixtures([]Fixture{{Label: "a", Kind: "A", ID: 1, Properties: props}})
+		if err == nil || !strings.Contains(err.Error(), "@aaa") {
+			t.Fatalf("SaveFixtures did not report the first property in name order. Got: %v", err)
+		}
+	}
+}
+
+func TestSaveFixturesMany(t *testing.T) {
+	defer ResetDB()
+
+	// more than a single SaveMulti takes
+	fixtures := make([]Fixture, fixtureBatchSize+10)
+	for i := range fixtures {
+		fixtures[i] = Fixture{Kind: "Many", ID: i + 1, Properties: map[string]interface{}{"N": i}}
+	}
+
+	if _, err := SaveFixtures(fixtures); err != nil {
+		t.Fatalf("SaveFixtures threw an error. Error: %v", err)
+	}
+
+	keys, err := datastore.NewQuery("Many").KeysOnly().GetAll(ctx, nil)
+	if err != nil || len(keys) != len(fixtures) {
+		t.Fatalf("SaveFixtures did not save every fixture. Error: %v; Got: %d", err, len(keys))
+	}
+}